@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// SignerConfig is how a producing service signs its events, see SignEvent
+// and keyRing.
+type SignerConfig struct {
+	Issuer      string        `yaml:"issuer" env:"JWT_ISSUER" validate:"required"`
+	Audience    []string      `yaml:"audience" env:"AUDIENCE" validate:"required"`
+	KeysDir     string        `yaml:"keysDir" env:"JWT_KEYS_DIR"`
+	SigningKID  string        `yaml:"signingKid" env:"JWT_SIGNING_KID"`
+	KeysRefresh time.Duration `yaml:"keysRefresh" env:"JWT_KEYS_REFRESH" default:"1m"`
+	PrivateKey  string        `yaml:"privateKey" env:"PRIVATE_KEY" secret:"true"`
+}
+
+func (c SignerConfig) Validate() error {
+	if c.KeysDir == "" && c.PrivateKey == "" {
+		return errors.New("keysDir (JWT_KEYS_DIR) or privateKey (PRIVATE_KEY) is required")
+	}
+	return nil
+}
+
+// VerifierConfig is how a consuming service verifies the events it consumes,
+// see VerifyEvent and jwksCache.
+type VerifierConfig struct {
+	Issuer       string        `yaml:"issuer" env:"JWT_ISSUER" validate:"required"`
+	Audience     []string      `yaml:"audience" env:"AUDIENCE" validate:"required"`
+	JWKSURL      string        `yaml:"jwksUrl" env:"JWKS_URL"`
+	JWKSFile     string        `yaml:"jwksFile" env:"JWKS_FILE"`
+	JWKSCacheTTL time.Duration `yaml:"jwksCacheTtl" env:"JWKS_CACHE_TTL" default:"5m"`
+	PublicKey    string        `yaml:"publicKey" env:"PUBLIC_KEY"`
+}
+
+func (c VerifierConfig) Validate() error {
+	if c.JWKSURL == "" && c.JWKSFile == "" && c.PublicKey == "" {
+		return errors.New("jwksUrl (JWKS_URL), jwksFile (JWKS_FILE) or publicKey (PUBLIC_KEY) is required")
+	}
+	return nil
+}
+
+// UserConfig is how the tokens of end users are checked, see
+// ValidateUserToken.
+type UserConfig struct {
+	PublicKey string   `yaml:"publicKey" env:"USER_PUBLIC_KEY" validate:"required"`
+	Issuer    string   `yaml:"issuer" env:"USER_JWT_ISSUER"`
+	Audience  []string `yaml:"audience" env:"USER_AUDIENCE" validate:"required"`
+}
+
+var (
+	signer   SignerConfig
+	verifier VerifierConfig
+	users    UserConfig
+)
+
+// ConfigureSigner sets the configuration of SignEvent and JWKS.
+func ConfigureSigner(cfg SignerConfig) {
+	signer = cfg
+}
+
+// ConfigureVerifier sets the configuration of VerifyEvent and
+// ValidateTokenAt.
+func ConfigureVerifier(cfg VerifierConfig) {
+	verifier = cfg
+}
+
+// ConfigureUsers sets the configuration of ValidateUserToken.
+func ConfigureUsers(cfg UserConfig) {
+	users = cfg
+}
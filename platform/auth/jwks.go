@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Errors of a key lookup. Neither says anything about the token: the key may
+// yet be published, or the JWKS be reachable again.
+var (
+	ErrUnknownKey      = errors.New("unknown key")
+	ErrKeysUnavailable = errors.New("keys unavailable")
+)
+
+// minRefetch limits how often an unknown kid triggers a fetch.
+const minRefetch = 30 * time.Second
+
+// jwksCache holds the keys service tokens are verified with. They are fetched
+// from the JWKSURL of the VerifierConfig and kept for JWKSCacheTTL; a token
+// signed with a kid not in the cache triggers a refetch, so rotated keys are
+// picked up right away. When the endpoint can't be reached the keys in the
+// JWKSFile are used. Without either, the single PublicKey verifies every token.
+//
+// Keys retired from the JWKSURL can be pinned in the JWKSFile, see pinnedKey.
+type jwksCache struct {
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	pinned    map[string]*rsa.PublicKey
+	read      time.Time
+}
+
+var verificationKeys jwksCache
+
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	url, file := verifier.JWKSURL, verifier.JWKSFile
+	if url == "" && file == "" {
+		pem, err := decodeKey("public key", verifier.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ParseRSAPublicKeyFromPEM(pem)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, known := c.keys[kid]
+	stale := time.Since(c.fetched) > verifier.JWKSCacheTTL
+	if c.keys == nil || ((stale || !known) && time.Since(c.attempted) > minRefetch) {
+		c.attempted = time.Now()
+		keys, err := loadJWKS(url, file)
+		if err != nil && c.keys == nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+		}
+		if err == nil {
+			c.keys, c.fetched = keys, time.Now()
+		}
+	}
+
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, nil
+		}
+	}
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// pinnedKey returns the key kid of the JWKS, or else the key kid pinned in
+// the JWKSFile, which holds the keys retired from the JWKSURL that events
+// signed with them are still verified with. The file is reread every
+// JWKSCacheTTL.
+func (c *jwksCache) pinnedKey(kid string) (*rsa.PublicKey, error) {
+	key, err := c.key(kid)
+	file := verifier.JWKSFile
+	if !errors.Is(err, ErrUnknownKey) || file == "" || kid == "" {
+		return key, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pinned == nil || time.Since(c.read) > verifier.JWKSCacheTTL {
+		set, err := readJWKS(file)
+		if err == nil {
+			c.pinned, err = set.publicKeys()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+		}
+		c.read = time.Now()
+	}
+
+	key, ok := c.pinned[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+func loadJWKS(url, file string) (map[string]*rsa.PublicKey, error) {
+	var errs []error
+	if url != "" {
+		set, err := fetchJWKS(url)
+		if err == nil {
+			return set.publicKeys()
+		}
+		errs = append(errs, err)
+	}
+	if file != "" {
+		set, err := readJWKS(file)
+		if err == nil {
+			return set.publicKeys()
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func fetchJWKS(url string) (*JWKSet, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	return &set, nil
+}
+
+func readJWKS(file string) (*JWKSet, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+	var set JWKSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+	return &set, nil
+}
+
+func (s *JWKSet) publicKeys() (map[string]*rsa.PublicKey, error) {
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range s.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
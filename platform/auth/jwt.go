@@ -0,0 +1,90 @@
+// Package auth issues and validates RS256 tokens.
+//
+// Events the services exchange carry a signature, see SignEvent. It is signed
+// with the key ring of the producing service and verified against its JWKS,
+// see keyRing and jwksCache, for the issuer and audience of SignerConfig and
+// VerifierConfig. Events produced before signatures carried a bearer service
+// token checked by ValidateTokenAt.
+//
+// User tokens authenticate HTTP callers. They are issued elsewhere and checked
+// against UserConfig, so neither kind of token is accepted in place of the
+// other.
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// decodeKey decodes the base64 PEM key called name.
+func decodeKey(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// ValidateTokenAt validates the service token as if it was checked at the
+// given time.
+func ValidateTokenAt(tokenString string, at time.Time) (jwt.MapClaims, error) {
+	return validate(tokenString, at, verifier.Issuer, verifier.Audience, verificationKeys.key)
+}
+
+func ValidateUserToken(tokenString string) (jwt.MapClaims, error) {
+	return validate(tokenString, time.Now(), users.Issuer, users.Audience, func(string) (*rsa.PublicKey, error) {
+		publicKey, err := decodeKey("user public key", users.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ParseRSAPublicKeyFromPEM(publicKey)
+	})
+}
+
+func validate(tokenString string, at time.Time, issuer string, audience []string, key func(kid string) (*rsa.PublicKey, error)) (jwt.MapClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(jwtToken *jwt.Token) (interface{}, error) {
+		if _, ok := jwtToken.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected method: %s", jwtToken.Header["alg"])
+		}
+		kid, _ := jwtToken.Header["kid"].(string)
+		return key(kid)
+	}, jwt.WithTimeFunc(func() time.Time { return at }))
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("validate: invalid")
+	}
+
+	iss, err := claims.GetIssuer()
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	if iss != issuer {
+		return nil, fmt.Errorf("validate: invalid issuer")
+	}
+
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	if err := checkAudience(audience, aud); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func checkAudience(audience []string, aud jwt.ClaimStrings) error {
+	if !slices.ContainsFunc(audience, func(a string) bool { return slices.Contains(aud, a) }) {
+		return fmt.Errorf("invalid audience. Expected: %s, Got: %v", strings.Join(audience, ","), aud)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is the public part of an RS256 signing key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func newJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k JWK) publicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("key %s: unsupported key type %s", k.Kid, k.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", k.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", k.Kid, err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint of key, used as the kid of keys
+// that aren't named.
+func thumbprint(key *rsa.PublicKey) string {
+	jwk := newJWK("", key)
+	data, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{jwk.E, jwk.Kty, jwk.N})
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// keyRing holds the signing keys of the service. They are read from the
+// <kid>.pem files in the KeysDir of the SignerConfig and reread every
+// KeysRefresh, so a key is rotated by adding its file and, once consumers have
+// fetched the new JWKS, pointing SigningKID at it. Without SigningKID the last
+// kid in sort order signs. Retired keys stay published until their file is
+// removed. Without KeysDir the single PrivateKey is used.
+type keyRing struct {
+	mu     sync.Mutex
+	loaded time.Time
+	keys   []signingKey
+	active signingKey
+}
+
+var signingKeys keyRing
+
+func (r *keyRing) get() ([]signingKey, signingKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keys != nil && time.Since(r.loaded) < signer.KeysRefresh {
+		return r.keys, r.active, nil
+	}
+
+	keys, active, err := loadSigningKeys()
+	if err != nil {
+		return nil, signingKey{}, err
+	}
+	r.keys, r.active, r.loaded = keys, active, time.Now()
+	return keys, active, nil
+}
+
+func loadSigningKeys() ([]signingKey, signingKey, error) {
+	dir := signer.KeysDir
+	if dir == "" {
+		pem, err := decodeKey("private key", signer.PrivateKey)
+		if err != nil {
+			return nil, signingKey{}, err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, signingKey{}, err
+		}
+		k := signingKey{thumbprint(&key.PublicKey), key}
+		return []signingKey{k}, k, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, signingKey{}, err
+	}
+	sort.Strings(files)
+
+	var keys []signingKey
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, signingKey{}, err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, signingKey{}, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, signingKey{strings.TrimSuffix(filepath.Base(file), ".pem"), key})
+	}
+	if len(keys) == 0 {
+		return nil, signingKey{}, fmt.Errorf("no signing keys in %s", dir)
+	}
+
+	kid := signer.SigningKID
+	if kid == "" {
+		return keys, keys[len(keys)-1], nil
+	}
+	for _, k := range keys {
+		if k.kid == kid {
+			return keys, k, nil
+		}
+	}
+	return nil, signingKey{}, fmt.Errorf("signing key %s not found in %s", kid, dir)
+}
+
+// JWKS returns the public keys of the signing keys, for services verifying
+// the tokens this one issues.
+func JWKS() (*JWKSet, error) {
+	keys, _, err := signingKeys.get()
+	if err != nil {
+		return nil, err
+	}
+
+	set := &JWKSet{Keys: []JWK{}}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, newJWK(k.kid, &k.key.PublicKey))
+	}
+	return set, nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sing3demons/go-platform/contracts"
+)
+
+const (
+	ScopeCatalogWrite = "catalog:write"
+	ScopePriceWrite   = "price:write"
+	// ScopeCatalogAdmin grants every other scope.
+	ScopeCatalogAdmin = "catalog:admin"
+)
+
+// eventScopes are the scopes one of which the signer of an event must have.
+// Deleting a product also deletes its prices, so catalog writers may sign
+// productPrice.deleted.
+var eventScopes = map[string][]string{
+	contracts.ProductCreated:      {ScopeCatalogWrite},
+	contracts.ProductUpdated:      {ScopeCatalogWrite},
+	contracts.ProductDeleted:      {ScopeCatalogWrite},
+	contracts.CategoryCreated:     {ScopeCatalogWrite},
+	contracts.CategoryUpdated:     {ScopeCatalogWrite},
+	contracts.CategoryDeleted:     {ScopeCatalogWrite},
+	contracts.ProductPriceCreated: {ScopePriceWrite},
+	contracts.ProductPriceUpdated: {ScopePriceWrite},
+	contracts.ProductPriceDeleted: {ScopePriceWrite, ScopeCatalogWrite},
+}
+
+// Scopes returns the scopes granted by claims: the space separated "scope"
+// claim, or a list of them, and the "roles" claim.
+func Scopes(claims jwt.MapClaims) []string {
+	var scopes []string
+	switch scope := claims["scope"].(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(scope)...)
+	case []any:
+		scopes = append(scopes, stringList(scope)...)
+	}
+	if roles, ok := claims["roles"].([]any); ok {
+		scopes = append(scopes, stringList(roles)...)
+	}
+	return scopes
+}
+
+// HasScope reports whether granted includes one of required. Without required
+// scopes any caller passes.
+func HasScope(granted []string, required ...string) bool {
+	if len(required) == 0 || slices.Contains(granted, ScopeCatalogAdmin) {
+		return true
+	}
+	return slices.ContainsFunc(required, func(s string) bool { return slices.Contains(granted, s) })
+}
+
+// EventScopes returns the scopes one of which the signer of an event of
+// eventType must have.
+func EventScopes(eventType string) []string {
+	return eventScopes[eventType]
+}
+
+func stringList(values []any) []string {
+	var list []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
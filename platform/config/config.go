@@ -0,0 +1,308 @@
+// Package config loads the typed configuration of a service.
+//
+// Each setting is a field of the config struct of the service, tagged with
+//
+//	yaml:"name"          its key in the YAML file
+//	env:"NAME"           the environment variable setting it
+//	flag:"name"          the command line flag setting it, if any
+//	default:"value"      its value when nothing else sets it
+//	validate:"required"  or validate:"oneof=a b c"
+//	secret:"true"        redacted by --print-config
+//
+// Nested structs group settings under their yaml key. A struct with a
+// Validate() error method is also checked with it.
+//
+// Later sources take precedence: defaults, values set in the struct before
+// Load, the YAML file named by --config or CONFIG_FILE, the environment, with
+// .env loaded into it, and flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load fills cfg, a pointer to a struct, and exits when the configuration is
+// invalid, or once it is printed with --print-config. It parses the command
+// line, so flags of the service must be defined before.
+func Load(cfg any) {
+	godotenv.Load(".env")
+
+	printConfig, err := load(cfg, flag.CommandLine, os.Args[1:])
+	if printConfig {
+		Print(os.Stdout, cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", indent(err))
+		os.Exit(2)
+	}
+	if printConfig {
+		os.Exit(0)
+	}
+}
+
+// load fills cfg from args parsed with flags and reports whether
+// --print-config was given.
+func load(cfg any, flags *flag.FlagSet, args []string) (bool, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("config: %T is not a pointer to a struct", cfg))
+	}
+
+	settings := fields(v.Elem(), "")
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML `file` with the settings")
+	printConfig := flags.Bool("print-config", false, "print the configuration, secrets redacted, and exit")
+	values := map[string]*string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			values[s.flag] = flags.String(s.flag, s.def, fmt.Sprintf("sets %s, see %s", s.path, s.env))
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if s.def != "" && s.value.IsZero() {
+			errs = append(errs, s.set("default", s.def))
+		}
+	}
+	if *file != "" {
+		errs = append(errs, readFile(*file, cfg))
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.env); s.env != "" && value != "" {
+			errs = append(errs, s.set(s.env, value))
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				errs = append(errs, s.set("--"+f.Name, *values[f.Name]))
+			}
+		}
+	})
+
+	err := errors.Join(errs...)
+	if err == nil {
+		err = validate(v.Elem(), settings)
+	}
+	return *printConfig, err
+}
+
+func readFile(file string, cfg any) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+func indent(err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	for i, line := range lines {
+		lines[i] = "  " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+type setting struct {
+	path     string
+	env      string
+	flag     string
+	def      string
+	validate string
+	secret   bool
+	value    reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields returns the settings of the struct v, whose keys start with prefix.
+func fields(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		path := prefix + key(f)
+		if f.Type.Kind() == reflect.Struct {
+			settings = append(settings, fields(v.Field(i), path+".")...)
+			continue
+		}
+		settings = append(settings, setting{
+			path:     path,
+			env:      f.Tag.Get("env"),
+			flag:     f.Tag.Get("flag"),
+			def:      f.Tag.Get("default"),
+			validate: f.Tag.Get("validate"),
+			secret:   f.Tag.Get("secret") == "true",
+			value:    v.Field(i),
+		})
+	}
+	return settings
+}
+
+// key returns the YAML key of f.
+func key(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// set parses value from source into the setting.
+func (s setting) set(source, value string) error {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not a duration", s.path, source, value)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not a boolean", s.path, source, value)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %q is not a number", s.path, source, value)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", s.path, v.Type())
+	}
+	return nil
+}
+
+// name describes where the setting is set, for errors.
+func (s setting) name() string {
+	sources := []string{}
+	if s.env != "" {
+		sources = append(sources, s.env)
+	}
+	if s.flag != "" {
+		sources = append(sources, "--"+s.flag)
+	}
+	if len(sources) == 0 {
+		return s.path
+	}
+	return fmt.Sprintf("%s (%s)", s.path, strings.Join(sources, ", "))
+}
+
+type validator interface {
+	Validate() error
+}
+
+// validate checks the validate tags of settings and the Validate methods of
+// the structs, starting with the innermost.
+func validate(v reflect.Value, settings []setting) error {
+	var errs []error
+	for _, s := range settings {
+		rule, arg, _ := strings.Cut(s.validate, "=")
+		switch rule {
+		case "required":
+			if s.value.IsZero() {
+				errs = append(errs, fmt.Errorf("%s: is required", s.name()))
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if !slices.Contains(options, s.value.String()) {
+				errs = append(errs, fmt.Errorf("%s: %q is not one of %s", s.name(), s.value.String(), strings.Join(options, ", ")))
+			}
+		}
+	}
+	return errors.Join(append(errs, validateStructs(v, ""))...)
+}
+
+func validateStructs(v reflect.Value, prefix string) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.IsExported() && f.Type.Kind() == reflect.Struct {
+			errs = append(errs, validateStructs(v.Field(i), prefix+key(f)+"."))
+		}
+	}
+
+	if s, ok := v.Addr().Interface().(validator); ok {
+		if err := s.Validate(); err != nil {
+			if prefix != "" {
+				err = fmt.Errorf("%s: %w", strings.TrimSuffix(prefix, "."), err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes cfg as YAML, with the values of secret settings redacted.
+func Print(w io.Writer, cfg any) error {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node(v)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func node(v reflect.Value) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		value := &yaml.Node{}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			value = node(v.Field(i))
+		case f.Tag.Get("secret") == "true" && !v.Field(i).IsZero():
+			value.SetString("<redacted>")
+		default:
+			value.Encode(v.Field(i).Interface())
+		}
+
+		k := &yaml.Node{}
+		k.SetString(key(f))
+		n.Content = append(n.Content, k, value)
+	}
+	return n
+}
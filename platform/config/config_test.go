@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	Addr  string   `yaml:"addr" env:"TEST_SERVER_ADDR"`
+	Hosts []string `yaml:"hosts" env:"TEST_SERVER_HOSTS"`
+}
+
+func (s testServer) Validate() error {
+	if strings.HasPrefix(s.Addr, "http") {
+		return errors.New("addr must not have a scheme")
+	}
+	return nil
+}
+
+type testConfig struct {
+	Name     string        `yaml:"name" env:"TEST_NAME" flag:"name" default:"default"`
+	Mode     string        `yaml:"mode" env:"TEST_MODE" default:"debug" validate:"oneof=debug release"`
+	URI      string        `yaml:"uri" env:"TEST_URI" validate:"required"`
+	Timeout  time.Duration `yaml:"timeout" env:"TEST_TIMEOUT" default:"5s"`
+	Workers  int           `yaml:"workers" env:"TEST_WORKERS" default:"1"`
+	Debug    bool          `yaml:"debug" env:"TEST_DEBUG"`
+	Password string        `yaml:"password" env:"TEST_PASSWORD" secret:"true"`
+	Token    string        `yaml:"token" env:"TEST_TOKEN" secret:"true"`
+	Server   testServer    `yaml:"server"`
+}
+
+// loadTest runs load on args, with the environment set to env and the YAML
+// file, when given, named by --config.
+func loadTest(t *testing.T, yaml string, env map[string]string, args ...string) (*testConfig, bool, error) {
+	t.Helper()
+
+	t.Setenv("CONFIG_FILE", "")
+	for _, name := range []string{"TEST_NAME", "TEST_MODE", "TEST_URI", "TEST_TIMEOUT", "TEST_WORKERS", "TEST_DEBUG", "TEST_PASSWORD", "TEST_TOKEN", "TEST_SERVER_ADDR", "TEST_SERVER_HOSTS"} {
+		t.Setenv(name, env[name])
+	}
+	if yaml != "" {
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", file}, args...)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	cfg := &testConfig{}
+	printConfig, err := load(cfg, flags, args)
+	return cfg, printConfig, err
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "default", want: "default"},
+		{name: "YAML over default", yaml: "name: yaml", want: "yaml"},
+		{name: "environment over YAML", yaml: "name: yaml", env: map[string]string{"TEST_NAME": "env"}, want: "env"},
+		{name: "flag over environment", yaml: "name: yaml", env: map[string]string{"TEST_NAME": "env"}, args: []string{"--name", "flag"}, want: "flag"},
+		{name: "flag set to the default", env: map[string]string{"TEST_NAME": "env"}, args: []string{"--name", "default"}, want: "default"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := map[string]string{"TEST_URI": "mongodb://localhost"}
+			for k, v := range tc.env {
+				env[k] = v
+			}
+			cfg, _, err := loadTest(t, tc.yaml, env, tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Name != tc.want {
+				t.Errorf("name = %q, want %q", cfg.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	cfg, _, err := loadTest(t, "uri: mongodb://yaml\nserver:\n  addr: yaml:80\n", map[string]string{
+		"TEST_TIMEOUT":      "1m30s",
+		"TEST_WORKERS":      "4",
+		"TEST_DEBUG":        "true",
+		"TEST_SERVER_HOSTS": "a, b,,c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.URI != "mongodb://yaml" || cfg.Server.Addr != "yaml:80" {
+		t.Errorf("uri = %q, server.addr = %q, want the values of the YAML file", cfg.URI, cfg.Server.Addr)
+	}
+	if cfg.Timeout != 90*time.Second || cfg.Workers != 4 || !cfg.Debug {
+		t.Errorf("timeout = %v, workers = %d, debug = %v, want 1m30s, 4, true", cfg.Timeout, cfg.Workers, cfg.Debug)
+	}
+	if strings.Join(cfg.Server.Hosts, "|") != "a|b|c" {
+		t.Errorf("server.hosts = %q, want [a b c]", cfg.Server.Hosts)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want []string
+	}{
+		{name: "required", want: []string{"uri (TEST_URI): is required"}},
+		{name: "oneof", env: map[string]string{"TEST_URI": "u", "TEST_MODE": "test"}, want: []string{`mode (TEST_MODE): "test" is not one of debug, release`}},
+		{name: "duration", env: map[string]string{"TEST_URI": "u", "TEST_TIMEOUT": "5"}, want: []string{`timeout: TEST_TIMEOUT: "5" is not a duration`}},
+		{name: "number", env: map[string]string{"TEST_URI": "u", "TEST_WORKERS": "many"}, want: []string{`workers: TEST_WORKERS: "many" is not a number`}},
+		{name: "boolean", env: map[string]string{"TEST_URI": "u", "TEST_DEBUG": "yes please"}, want: []string{`debug: TEST_DEBUG: "yes please" is not a boolean`}},
+		{name: "unknown YAML key", yaml: "uri: u\nnmae: typo\n", want: []string{"field nmae not found"}},
+		{name: "Validate of a nested struct", env: map[string]string{"TEST_URI": "u", "TEST_SERVER_ADDR": "http://host"}, want: []string{"server: addr must not have a scheme"}},
+		{name: "every error", env: map[string]string{"TEST_MODE": "test"}, want: []string{"uri (TEST_URI): is required", `mode (TEST_MODE): "test" is not one of`}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := loadTest(t, tc.yaml, tc.env)
+			if err == nil {
+				t.Fatal("load() = nil, want an error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("load() = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPrintConfig(t *testing.T) {
+	cfg, printConfig, err := loadTest(t, "", map[string]string{"TEST_URI": "u", "TEST_PASSWORD": "hunter2"}, "--print-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printConfig {
+		t.Error("load() didn't report --print-config")
+	}
+
+	var out strings.Builder
+	if err := Print(&out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	if strings.Contains(printed, "hunter2") || !strings.Contains(printed, "password: <redacted>") {
+		t.Errorf("printed\n%s\nwant the password redacted", printed)
+	}
+	if !strings.Contains(printed, `token: ""`) {
+		t.Errorf("printed\n%s\nwant the unset token empty", printed)
+	}
+	if !strings.Contains(printed, "name: default") || !strings.Contains(printed, "server:\n  addr:") {
+		t.Errorf("printed\n%s\nwant the other settings by their YAML keys", printed)
+	}
+}
@@ -0,0 +1,209 @@
+// Package contracts defines the events exchanged over Kafka: the envelope
+// every event is wrapped in, a JSON Schema per event type and version, and
+// the upcasters that bring bodies of older versions to the latest one.
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Event types. Each is published on the topic of the same name.
+const (
+	ProductCreated      = "product.created"
+	ProductUpdated      = "product.updated"
+	ProductDeleted      = "product.deleted"
+	ProductPriceCreated = "productPrice.created"
+	ProductPriceUpdated = "productPrice.updated"
+	ProductPriceDeleted = "productPrice.deleted"
+	CategoryCreated     = "category.created"
+	CategoryUpdated     = "category.updated"
+	CategoryDeleted     = "category.deleted"
+)
+
+// Record headers carrying the envelope. The Kafka record value only holds the
+// body.
+const (
+	HeaderEventID       = "eventId"
+	HeaderEventType     = "eventType"
+	HeaderSchemaVersion = "schemaVersion"
+	HeaderOccurredAt    = "occurredAt"
+	HeaderAggregateID   = "aggregateId"
+)
+
+var ErrInvalidEvent = errors.New("invalid event")
+
+// Envelope is an event together with its metadata. Header holds the metadata
+// of the request that caused the event, such as the request id, trace context
+// and authorization.
+type Envelope struct {
+	EventID       string
+	EventType     string
+	SchemaVersion int
+	OccurredAt    time.Time
+	AggregateID   string
+	Header        map[string]string
+	Body          json.RawMessage
+}
+
+// NewEnvelope wraps body in an event of the latest schema version of
+// eventType. The body must validate against that schema.
+func NewEnvelope(eventID, eventType, aggregateID string, header map[string]string, body any) (*Envelope, error) {
+	c, ok := registry[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidEvent, eventType)
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.validate(c.latest, raw); err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		EventID:       eventID,
+		EventType:     eventType,
+		SchemaVersion: c.latest,
+		OccurredAt:    time.Now().UTC(),
+		AggregateID:   aggregateID,
+		Header:        header,
+		Body:          raw,
+	}, nil
+}
+
+// Headers returns the record headers of the event: the request metadata and
+// the envelope fields.
+func (e *Envelope) Headers() map[string]string {
+	headers := map[string]string{}
+	for key, value := range e.Header {
+		if value != "" {
+			headers[key] = value
+		}
+	}
+	headers[HeaderEventID] = e.EventID
+	headers[HeaderEventType] = e.EventType
+	headers[HeaderSchemaVersion] = strconv.Itoa(e.SchemaVersion)
+	headers[HeaderOccurredAt] = e.OccurredAt.Format(time.RFC3339Nano)
+	headers[HeaderAggregateID] = e.AggregateID
+	return headers
+}
+
+// Decode reads an event consumed from topic, validates it against the schema
+// of its version and upcasts the body to the latest version.
+//
+// Events carry their envelope in the record headers and only the body in the
+// value. Older events have the envelope in the value instead, and those
+// written before the envelope existed only a header and a body there; the
+// latter are read as version 1 of the topic's event type.
+func Decode(topic string, headers map[string]string, value []byte) (*Envelope, error) {
+	e, err := envelope(headers, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+
+	if e.EventType == "" {
+		e.EventType = topic
+	}
+	if e.EventType != topic {
+		return nil, fmt.Errorf("%w: %s event on topic %s", ErrInvalidEvent, e.EventType, topic)
+	}
+	if e.SchemaVersion == 0 {
+		e.SchemaVersion = 1
+	}
+
+	c, ok := registry[e.EventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidEvent, e.EventType)
+	}
+	if err := c.validate(e.SchemaVersion, e.Body); err != nil {
+		return nil, err
+	}
+
+	body, err := c.upcast(e.SchemaVersion, e.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.validate(c.latest, body); err != nil {
+		return nil, err
+	}
+	e.Body = body
+	e.SchemaVersion = c.latest
+
+	if e.AggregateID == "" {
+		var ref struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(body, &ref)
+		e.AggregateID = ref.ID
+	}
+
+	return e, nil
+}
+
+func envelope(headers map[string]string, value []byte) (*Envelope, error) {
+	if eventType := headers[HeaderEventType]; eventType != "" {
+		e := &Envelope{
+			EventID:     headers[HeaderEventID],
+			EventType:   eventType,
+			AggregateID: headers[HeaderAggregateID],
+			Header:      map[string]string{},
+			Body:        value,
+		}
+		if v := headers[HeaderSchemaVersion]; v != "" {
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("schema version %q", v)
+			}
+			e.SchemaVersion = version
+		}
+		if v := headers[HeaderOccurredAt]; v != "" {
+			occurredAt, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, err
+			}
+			e.OccurredAt = occurredAt
+		}
+		for key, value := range headers {
+			switch key {
+			case HeaderEventID, HeaderEventType, HeaderSchemaVersion, HeaderOccurredAt, HeaderAggregateID:
+			default:
+				e.Header[key] = value
+			}
+		}
+		return e, nil
+	}
+
+	var legacy struct {
+		EventID       string          `json:"eventId"`
+		EventType     string          `json:"eventType"`
+		SchemaVersion int             `json:"schemaVersion"`
+		OccurredAt    time.Time       `json:"occurredAt"`
+		AggregateID   string          `json:"aggregateId"`
+		Header        map[string]any  `json:"header"`
+		Body          json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(value, &legacy); err != nil {
+		return nil, err
+	}
+
+	e := &Envelope{
+		EventID:       legacy.EventID,
+		EventType:     legacy.EventType,
+		SchemaVersion: legacy.SchemaVersion,
+		OccurredAt:    legacy.OccurredAt,
+		AggregateID:   legacy.AggregateID,
+		Header:        map[string]string{},
+		Body:          legacy.Body,
+	}
+	for key, value := range legacy.Header {
+		if value != nil {
+			e.Header[key] = fmt.Sprint(value)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,176 @@
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRegistryKnowsEveryEventType(t *testing.T) {
+	for _, eventType := range []string{
+		ProductCreated, ProductUpdated, ProductDeleted,
+		ProductPriceCreated, ProductPriceUpdated, ProductPriceDeleted,
+		CategoryCreated, CategoryUpdated, CategoryDeleted,
+	} {
+		if _, ok := registry[eventType]; !ok {
+			t.Errorf("no schema for %s", eventType)
+		}
+	}
+}
+
+func TestNewEnvelopeValidatesTheBody(t *testing.T) {
+	if _, err := NewEnvelope("e1", ProductDeleted, "p1", nil, map[string]any{"id": "p1"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("NewEnvelope() = %v, want %v", err, ErrInvalidEvent)
+	}
+	if _, err := NewEnvelope("e1", "product.renamed", "p1", nil, map[string]any{"id": "p1"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("NewEnvelope() of an unknown type = %v, want %v", err, ErrInvalidEvent)
+	}
+}
+
+func TestEnvelopeHeadersRoundTrip(t *testing.T) {
+	body := map[string]any{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z", "version": 3}
+	e, err := NewEnvelope("e1", ProductDeleted, "p1", map[string]string{"request_id": "r1", "traceparent": ""}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := e.Headers()
+	if _, ok := headers["traceparent"]; ok {
+		t.Error("empty request metadata is carried in the headers")
+	}
+
+	decoded, err := Decode(ProductDeleted, headers, e.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.EventID != "e1" || decoded.AggregateID != "p1" || decoded.SchemaVersion != e.SchemaVersion || !decoded.OccurredAt.Equal(e.OccurredAt) {
+		t.Errorf("decoded %+v, want %+v", decoded, e)
+	}
+	if decoded.Header["request_id"] != "r1" {
+		t.Errorf("decoded header %v, want the request id", decoded.Header)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name    string
+		topic   string
+		headers map[string]string
+		value   string
+		body    map[string]any
+		header  map[string]string
+		err     bool
+	}{
+		{
+			name:    "latest version",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "2"},
+			value:   `{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"}`,
+			body:    map[string]any{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"},
+		},
+		{
+			name:    "delete_date of v1 is upcast to deleteDate",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "1"},
+			value:   `{"id": "p1", "delete_date": "2024-01-02T00:00:00Z"}`,
+			body:    map[string]any{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"},
+		},
+		{
+			name:  "envelope in the value",
+			topic: CategoryDeleted,
+			value: `{"eventId": "e1", "eventType": "category.deleted", "schemaVersion": 1, "body": {"id": "c1", "delete_date": "2024-01-02T00:00:00Z"}}`,
+			body:  map[string]any{"id": "c1", "deleteDate": "2024-01-02T00:00:00Z"},
+		},
+		{
+			name:   "header and body written before the envelope",
+			topic:  ProductPriceDeleted,
+			value:  `{"header": {"Authorization": "Bearer t", "status": 202, "none": null}, "body": {"id": "r1", "delete_date": "2024-01-02T00:00:00Z"}}`,
+			body:   map[string]any{"id": "r1", "deleteDate": "2024-01-02T00:00:00Z"},
+			header: map[string]string{"Authorization": "Bearer t", "status": "202"},
+		},
+		{
+			name:    "event of another topic",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: CategoryDeleted, HeaderSchemaVersion: "2"},
+			value:   `{"id": "c1", "deleteDate": "2024-01-02T00:00:00Z"}`,
+			err:     true,
+		},
+		{
+			name:    "body against the schema",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "2"},
+			value:   `{"id": "p1"}`,
+			err:     true,
+		},
+		{
+			name:    "v1 body missing the v1 field",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "1"},
+			value:   `{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"}`,
+			err:     true,
+		},
+		{
+			name:    "unknown version",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "9"},
+			value:   `{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"}`,
+			err:     true,
+		},
+		{
+			name:    "malformed schema version",
+			topic:   ProductDeleted,
+			headers: map[string]string{HeaderEventType: ProductDeleted, HeaderSchemaVersion: "v2"},
+			value:   `{"id": "p1", "deleteDate": "2024-01-02T00:00:00Z"}`,
+			err:     true,
+		},
+		{
+			name:  "malformed value",
+			topic: ProductDeleted,
+			value: `p1`,
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := Decode(tc.topic, tc.headers, []byte(tc.value))
+			if tc.err {
+				if !errors.Is(err, ErrInvalidEvent) {
+					t.Fatalf("Decode() = %v, want %v", err, ErrInvalidEvent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.EventType != tc.topic || e.SchemaVersion != registry[tc.topic].latest {
+				t.Errorf("decoded a v%d %s event, want the latest version of %s", e.SchemaVersion, e.EventType, tc.topic)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(e.Body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != len(tc.body) {
+				t.Errorf("body %v, want %v", body, tc.body)
+			}
+			for key, want := range tc.body {
+				if body[key] != want {
+					t.Errorf("body %v, want %v", body, tc.body)
+					break
+				}
+			}
+			if e.AggregateID != tc.body["id"] {
+				t.Errorf("aggregate id %q, want the id of the body", e.AggregateID)
+			}
+			for key, want := range tc.header {
+				if e.Header[key] != want {
+					t.Errorf("header %v, want %v", e.Header, tc.header)
+				}
+			}
+			if len(e.Header) != len(tc.header) {
+				t.Errorf("header %v, want %v", e.Header, tc.header)
+			}
+		})
+	}
+}
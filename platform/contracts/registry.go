@@ -0,0 +1,113 @@
+package contracts
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+var schemaName = regexp.MustCompile(`^(.+)\.v(\d+)\.json$`)
+
+// Upcaster turns the body of one schema version into the next one.
+type Upcaster func(body map[string]any) (map[string]any, error)
+
+type contract struct {
+	schemas   map[int]*Schema
+	upcasters map[int]Upcaster
+	latest    int
+}
+
+// registry holds the contract of every event type, loaded from
+// schemas/<eventType>.v<version>.json.
+var registry = loadRegistry()
+
+func loadRegistry() map[string]*contract {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	contracts := map[string]*contract{}
+	for _, entry := range entries {
+		m := schemaName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			panic(fmt.Sprintf("contracts: unexpected schema file %s", entry.Name()))
+		}
+		version, _ := strconv.Atoi(m[2])
+
+		data, err := schemaFiles.ReadFile(path.Join("schemas", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var schema Schema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			panic(fmt.Sprintf("contracts: %s: %v", entry.Name(), err))
+		}
+
+		c, ok := contracts[m[1]]
+		if !ok {
+			c = &contract{schemas: map[int]*Schema{}, upcasters: map[int]Upcaster{}}
+			contracts[m[1]] = c
+		}
+		c.schemas[version] = &schema
+		c.latest = max(c.latest, version)
+	}
+
+	for eventType, byVersion := range upcasters {
+		c, ok := contracts[eventType]
+		if !ok {
+			panic(fmt.Sprintf("contracts: upcaster for unknown event type %s", eventType))
+		}
+		c.upcasters = byVersion
+	}
+	for eventType, c := range contracts {
+		for version := 1; version < c.latest; version++ {
+			if c.upcasters[version] == nil {
+				panic(fmt.Sprintf("contracts: %s has no upcaster from version %d", eventType, version))
+			}
+		}
+	}
+
+	return contracts
+}
+
+func (c *contract) validate(version int, body json.RawMessage) error {
+	schema, ok := c.schemas[version]
+	if !ok {
+		return fmt.Errorf("%w: unknown schema version %d", ErrInvalidEvent, version)
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if err := schema.Validate("body", value); err != nil {
+		return fmt.Errorf("%w: v%d: %v", ErrInvalidEvent, version, err)
+	}
+	return nil
+}
+
+// upcast applies the upcasters from version up to the latest version.
+func (c *contract) upcast(version int, body json.RawMessage) (json.RawMessage, error) {
+	if version == c.latest {
+		return body, nil
+	}
+
+	var value map[string]any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	for ; version < c.latest; version++ {
+		var err error
+		if value, err = c.upcasters[version](value); err != nil {
+			return nil, fmt.Errorf("%w: upcast from v%d: %v", ErrInvalidEvent, version, err)
+		}
+	}
+	return json.Marshal(value)
+}
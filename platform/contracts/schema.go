@@ -0,0 +1,121 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Schema is the subset of JSON Schema the event schemas use: type,
+// properties, required, additionalProperties, items, enum, minLength,
+// minimum and the date-time format.
+type Schema struct {
+	Type                 Types              `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	MinLength            *int               `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+	Format               string             `json:"format"`
+}
+
+// Types is a type name or a list of them.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = Types{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+// Validate checks a value decoded with encoding/json against the schema.
+// Errors name the offending value by its path below at.
+func (s *Schema) Validate(at string, value any) error {
+	if len(s.Type) != 0 && !slices.ContainsFunc(s.Type, func(name string) bool { return is(name, value) }) {
+		return fmt.Errorf("%s: expected %v", at, s.Type)
+	}
+	if len(s.Enum) != 0 && !slices.Contains(s.Enum, value) {
+		return fmt.Errorf("%s: expected one of %v", at, s.Enum)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.MinLength != nil && len([]rune(v)) < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d", at, *s.MinLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fmt.Errorf("%s: not a date-time", at)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: less than %v", at, *s.Minimum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.Validate(fmt.Sprintf("%s[%d]", at, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s: required", at, name)
+			}
+		}
+		for name, item := range v {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s: not allowed", at, name)
+				}
+				continue
+			}
+			if err := property.Validate(at+"."+name, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func is(name string, value any) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package contracts
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["id"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string", "minLength": 1},
+			"status": {"enum": ["active", "inActive"]},
+			"version": {"type": "integer", "minimum": 0},
+			"at": {"type": "string", "format": "date-time"},
+			"tags": {"type": ["array", "null"], "items": {"type": "string"}}
+		}
+	}`), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		body string
+		err  string
+	}{
+		{body: `{"id": "p1", "status": "active", "version": 2, "at": "2024-01-02T03:04:05.123Z", "tags": ["a"]}`},
+		{body: `{"id": "p1", "tags": null}`},
+		{body: `[]`, err: "body: expected [object]"},
+		{body: `{}`, err: "body.id: required"},
+		{body: `{"id": ""}`, err: "body.id: shorter than 1"},
+		{body: `{"id": 1}`, err: "body.id: expected [string]"},
+		{body: `{"id": "p1", "other": 1}`, err: "body.other: not allowed"},
+		{body: `{"id": "p1", "status": "gone"}`, err: "body.status: expected one of [active inActive]"},
+		{body: `{"id": "p1", "version": 1.5}`, err: "body.version: expected [integer]"},
+		{body: `{"id": "p1", "version": -1}`, err: "body.version: less than 0"},
+		{body: `{"id": "p1", "at": "2024-01-02"}`, err: "body.at: not a date-time"},
+		{body: `{"id": "p1", "tags": ["a", 1]}`, err: "body.tags[1]: expected [string]"},
+	}
+	for _, tc := range cases {
+		var value any
+		if err := json.Unmarshal([]byte(tc.body), &value); err != nil {
+			t.Fatal(err)
+		}
+
+		err := schema.Validate("body", value)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.body, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: error %v, want %q", tc.body, err, tc.err)
+		}
+	}
+}
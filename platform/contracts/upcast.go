@@ -0,0 +1,19 @@
+package contracts
+
+// upcasters are keyed by event type and the version they upcast from.
+var upcasters = map[string]map[int]Upcaster{
+	// v2 names the delete date deleteDate like every other field
+	ProductDeleted:      {1: rename("delete_date", "deleteDate")},
+	ProductPriceDeleted: {1: rename("delete_date", "deleteDate")},
+	CategoryDeleted:     {1: rename("delete_date", "deleteDate")},
+}
+
+func rename(from, to string) Upcaster {
+	return func(body map[string]any) (map[string]any, error) {
+		if v, ok := body[from]; ok {
+			body[to] = v
+			delete(body, from)
+		}
+		return body, nil
+	}
+}
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/auth"
+	"github.com/sing3demons/go-platform/contracts"
+	"github.com/sirupsen/logrus"
+)
+
+// Replay policies, the ReplayPolicy of the ConsumerConfig, decide how events
+// are authenticated when a topic is replayed.
+const (
+	// ReplayStrict authenticates replayed events like live ones.
+	ReplayStrict = "strict"
+	// ReplayLenient also accepts events signed with a retired key pinned in
+	// the JWKSFile of the verifier, and bearer tokens issued before scopes
+	// existed.
+	ReplayLenient = "lenient"
+)
+
+// Authenticate rejects events that aren't signed by a producing service (see
+// auth.SignEvent) or whose signer lacks the scope the event type requires.
+// It runs before Contract, as the signature covers the value as produced.
+//
+// Events produced before signatures carry a bearer service token instead,
+// in their Authorization record header or in the header of their value. On
+// a replay those are checked as of the time the event was produced, as they
+// have long expired since.
+//
+// An event whose signing key can't be looked up, because it isn't published
+// yet or the JWKS can't be fetched, is retried; one that fails verification
+// is poison.
+//
+// replayPolicy is the policy of the replay the events are handled for, empty
+// when they are consumed live.
+func Authenticate(next EventHandler, replayPolicy string, logger *logrus.Logger) EventHandler {
+	replay, policy := replayPolicy != "", replayPolicy
+	if !replay {
+		policy = ReplayStrict
+	}
+
+	return HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		headers := Headers(msg)
+		var (
+			scopes   []string
+			unscoped bool
+			err      error
+		)
+		if signature := headers[HeaderSignature]; signature != "" {
+			scopes, err = verifySignature(msg, headers, signature, policy)
+		} else {
+			scopes, unscoped, err = validateBearer(msg, headers, replay, policy)
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"error":     err,
+			}).Error("authenticate event error")
+			if errors.Is(err, auth.ErrUnknownKey) || errors.Is(err, auth.ErrKeysUnavailable) {
+				return Retryable(err)
+			}
+			return Poison(err)
+		}
+
+		if unscoped {
+			return next.Handle(ctx, msg)
+		}
+		if required := auth.EventScopes(msg.Topic); !auth.HasScope(scopes, required...) {
+			err := fmt.Errorf("%s requires one of the scopes %v, signer has %v", msg.Topic, required, scopes)
+			logger.WithFields(logrus.Fields{
+				"topic": msg.Topic,
+				"error": err,
+			}).Error("forbidden event")
+			return Poison(err)
+		}
+		return next.Handle(ctx, msg)
+	})
+}
+
+// verifySignature returns the scopes of the signer of msg. With the lenient
+// policy the signature may be verified with a retired key, see
+// auth.VerifyReplayedEvent.
+func verifySignature(msg *sarama.ConsumerMessage, headers map[string]string, signature, policy string) ([]string, error) {
+	verify := auth.VerifyEvent
+	if policy == ReplayLenient {
+		verify = auth.VerifyReplayedEvent
+	}
+	signed, err := verify(signature, msg.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if signed.EventType != msg.Topic {
+		return nil, fmt.Errorf("signature of a %s event on topic %s", signed.EventType, msg.Topic)
+	}
+	if signed.EventID != headers[HeaderEventID] {
+		return nil, fmt.Errorf("signature of event %s on event %s", signed.EventID, headers[HeaderEventID])
+	}
+	return signed.Scopes, nil
+}
+
+// validateBearer returns the scopes of the bearer token of msg, and whether
+// the lenient policy accepts it without scopes as it was issued before scopes
+// existed.
+func validateBearer(msg *sarama.ConsumerMessage, headers map[string]string, replay bool, policy string) ([]string, bool, error) {
+	authorization := headers[HeaderAuthorization]
+	if authorization == "" {
+		event, err := contracts.Decode(msg.Topic, headers, msg.Value)
+		if err != nil {
+			return nil, false, err
+		}
+		authorization = event.Header[HeaderAuthorization]
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return nil, false, fmt.Errorf("event is neither signed nor carries a bearer token")
+	}
+
+	at := time.Now()
+	if replay {
+		at = msg.Timestamp
+	}
+	claims, err := auth.ValidateTokenAt(token, at)
+	if err != nil {
+		return nil, false, err
+	}
+
+	scopes := auth.Scopes(claims)
+	return scopes, scopes == nil && policy == ReplayLenient, nil
+}
@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+type consumerHandler struct {
+	eventHandler EventHandler
+	retry        *RetryPipeline
+	logger       *logrus.Logger
+}
+
+func NewConsumerHandler(eventHandler EventHandler, retry *RetryPipeline, logger *logrus.Logger) sarama.ConsumerGroupHandler {
+	return consumerHandler{eventHandler, retry, logger}
+}
+
+func (obj consumerHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (obj consumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (obj consumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		err := obj.retry.Process(session.Context(), msg, obj.eventHandler)
+		if err != nil {
+			obj.logger.WithFields(logrus.Fields{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"error":     err,
+			}).Error("consume message error")
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+
+	return nil
+}
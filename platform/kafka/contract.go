@@ -0,0 +1,47 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/contracts"
+	"github.com/sirupsen/logrus"
+)
+
+// Contract validates events against their contract and hands next the body
+// upcast to the latest schema version, so handlers only decode that one. The
+// envelope and request metadata are passed on as record headers, including
+// for older events that carried them in the value.
+func Contract(next EventHandler, logger *logrus.Logger) EventHandler {
+	return HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		headers := Headers(msg)
+		event, err := contracts.Decode(msg.Topic, headers, msg.Value)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"error":     err,
+			}).Error("event breaks its contract")
+			return Poison(err)
+		}
+
+		for key, value := range event.Headers() {
+			if value == "" {
+				continue
+			}
+			if _, ok := headers[key]; !ok || key == contracts.HeaderSchemaVersion {
+				headers[key] = value
+			}
+		}
+
+		upcast := *msg
+		upcast.Value = event.Body
+		records := RecordHeaders(headers)
+		upcast.Headers = make([]*sarama.RecordHeader, len(records))
+		for i := range records {
+			upcast.Headers[i] = &records[i]
+		}
+		return next.Handle(ctx, &upcast)
+	})
+}
@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PoisonError marks a message that can never be handled, e.g. a payload that
+// does not decode or a token that does not validate. Poison messages skip
+// retries and go straight to the dead-letter topic.
+type PoisonError struct {
+	Err error
+}
+
+func (e *PoisonError) Error() string {
+	return fmt.Sprintf("poison message: %v", e.Err)
+}
+
+func (e *PoisonError) Unwrap() error {
+	return e.Err
+}
+
+// RetryableError marks a transient failure such as a database timeout.
+// Errors that are not wrapped at all are treated as retryable too.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retryable: %v", e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+func Poison(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PoisonError{err}
+}
+
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{err}
+}
+
+func IsPoison(err error) bool {
+	var poison *PoisonError
+	return errors.As(err, &poison)
+}
+
+func IsRetryable(err error) bool {
+	return err != nil && !IsPoison(err)
+}
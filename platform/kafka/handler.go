@@ -0,0 +1,20 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+)
+
+// EventHandler handles a single Kafka message. The returned error decides
+// what happens to the offset: nil commits it, a PoisonError dead-letters the
+// message and anything else is retried.
+type EventHandler interface {
+	Handle(ctx context.Context, msg *sarama.ConsumerMessage) error
+}
+
+type HandlerFunc func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return f(ctx, msg)
+}
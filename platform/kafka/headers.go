@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// Request metadata carried in record headers next to the event envelope.
+// HeaderSubject and HeaderScope are the caller the event is signed for.
+const (
+	HeaderSignature     = "signature"
+	HeaderSubject       = "user_id"
+	HeaderScope         = "scope"
+	HeaderRequestID     = "request_id"
+	HeaderTraceparent   = "traceparent"
+	HeaderTracestate    = "tracestate"
+	HeaderAuthorization = "Authorization"
+)
+
+// Headers returns the record headers of msg.
+func Headers(msg *sarama.ConsumerMessage) map[string]string {
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return headers
+}
+
+// Metadata returns the record headers of msg without credentials, for logging.
+func Metadata(msg *sarama.ConsumerMessage) map[string]string {
+	headers := Headers(msg)
+	delete(headers, HeaderAuthorization)
+	return headers
+}
+
+// RecordHeaders turns headers into record headers, sorted by key.
+func RecordHeaders(headers map[string]string) []sarama.RecordHeader {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]sarama.RecordHeader, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, sarama.RecordHeader{Key: []byte(key), Value: []byte(headers[key])})
+	}
+	return records
+}
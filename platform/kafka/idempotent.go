@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/contracts"
+	"github.com/sirupsen/logrus"
+)
+
+const HeaderEventID = contracts.HeaderEventID
+
+// EventID returns the id the producer stamped on msg. Messages produced
+// before event ids existed fall back to their original Kafka position, which
+// is just as stable when a topic is replayed.
+func EventID(msg *sarama.ConsumerMessage) string {
+	headers := Headers(msg)
+
+	if id := headers[HeaderEventID]; id != "" {
+		return id
+	}
+	if offset, ok := headers["x-original-offset"]; ok {
+		return fmt.Sprintf("%s/%s/%s", headers["x-original-topic"], headers["x-original-partition"], offset)
+	}
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// Idempotent skips events already recorded in the ledger and records the ones
+// next handled successfully.
+func Idempotent(ledger Ledger, next EventHandler, logger *logrus.Logger) EventHandler {
+	return HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		eventID := EventID(msg)
+
+		processed, err := ledger.IsProcessed(ctx, eventID)
+		if err != nil {
+			return Retryable(err)
+		}
+		if processed {
+			logger.WithFields(logrus.Fields{
+				"eventId":   eventID,
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+			}).Info("skip processed event")
+			return nil
+		}
+
+		if err := next.Handle(ctx, msg); err != nil {
+			return err
+		}
+
+		if err := ledger.MarkProcessed(ctx, eventID, msg.Topic); err != nil {
+			return Retryable(err)
+		}
+		return nil
+	})
+}
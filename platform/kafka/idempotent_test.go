@@ -0,0 +1,126 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// memoryLedger keeps the processed event ids in memory. Its methods fail
+// with err when it is set.
+type memoryLedger struct {
+	processed map[string]string
+	err       error
+}
+
+func (l *memoryLedger) IsProcessed(ctx context.Context, eventID string) (bool, error) {
+	_, ok := l.processed[eventID]
+	return ok, l.err
+}
+
+func (l *memoryLedger) MarkProcessed(ctx context.Context, eventID string, topic string) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.processed[eventID] = topic
+	return nil
+}
+
+func TestEventID(t *testing.T) {
+	header := func(key, value string) *sarama.RecordHeader {
+		return &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+	}
+	cases := []struct {
+		name string
+		msg  *sarama.ConsumerMessage
+		want string
+	}{
+		{
+			name: "stamped by the producer",
+			msg:  &sarama.ConsumerMessage{Topic: "t.retry.1", Offset: 4, Headers: []*sarama.RecordHeader{header(HeaderEventID, "e1"), header(HeaderOriginalOffset, "7")}},
+			want: "e1",
+		},
+		{
+			name: "forwarded to a retry topic",
+			msg: &sarama.ConsumerMessage{Topic: "t.retry.1", Offset: 4, Headers: []*sarama.RecordHeader{
+				header(HeaderOriginalTopic, "t"), header(HeaderOriginalPartition, "3"), header(HeaderOriginalOffset, "7"),
+			}},
+			want: "t/3/7",
+		},
+		{
+			name: "first delivery",
+			msg:  &sarama.ConsumerMessage{Topic: "t", Partition: 3, Offset: 7},
+			want: "t/3/7",
+		},
+	}
+	for _, tc := range cases {
+		if got := EventID(tc.msg); got != tc.want {
+			t.Errorf("%s: EventID() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	failure := errors.New("connection refused")
+	cases := []struct {
+		name      string
+		processed bool
+		ledgerErr error
+		err       error
+		handled   bool
+		marked    bool
+		poison    bool
+		retryable bool
+	}{
+		{name: "new event", handled: true, marked: true},
+		{name: "processed event is skipped", processed: true, marked: true},
+		{name: "failed event is not marked", err: failure, handled: true, retryable: true},
+		{name: "poison event is not marked", err: Poison(failure), handled: true, poison: true},
+		{name: "ledger error", ledgerErr: failure, retryable: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ledger := &memoryLedger{processed: map[string]string{}, err: tc.ledgerErr}
+			if tc.processed {
+				ledger.processed["e1"] = "t"
+			}
+
+			handled := false
+			next := HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+				handled = true
+				return tc.err
+			})
+			msg := &sarama.ConsumerMessage{Topic: "t", Headers: []*sarama.RecordHeader{{Key: []byte(HeaderEventID), Value: []byte("e1")}}}
+			err := Idempotent(ledger, next, discardLogger()).Handle(context.Background(), msg)
+
+			if handled != tc.handled {
+				t.Errorf("handled = %v, want %v", handled, tc.handled)
+			}
+			if _, marked := ledger.processed["e1"]; marked != tc.marked {
+				t.Errorf("marked = %v, want %v", marked, tc.marked)
+			}
+			if IsPoison(err) != tc.poison {
+				t.Errorf("Handle() = %v, want poison %v", err, tc.poison)
+			}
+			if (err != nil && !IsPoison(err)) != tc.retryable {
+				t.Errorf("Handle() = %v, want retryable %v", err, tc.retryable)
+			}
+		})
+	}
+}
+
+func TestIdempotentRetriesWhenMarkingFails(t *testing.T) {
+	ledger := &memoryLedger{processed: map[string]string{}}
+	next := HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error {
+		ledger.err = errors.New("connection refused")
+		return nil
+	})
+
+	err := Idempotent(ledger, next, discardLogger()).Handle(context.Background(), &sarama.ConsumerMessage{Topic: "t"})
+	if err == nil || IsPoison(err) {
+		t.Errorf("Handle() = %v, want a retryable error", err)
+	}
+}
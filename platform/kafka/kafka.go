@@ -0,0 +1,140 @@
+// Package kafka holds what the services share to produce and consume events:
+// the sarama setup, the run loop of consumer groups, the event handler chain
+// (contract validation, authentication, idempotency), the retry pipeline and
+// topic replays.
+//
+// Events are keyed by the id of the aggregate they belong to, so all events of
+// one aggregate on a topic land on the same partition and are handled in the
+// order they were produced. There is no such order across the topics of an
+// aggregate, which are consumed concurrently, nor for a message parked on a
+// retry topic. Handlers gate on the version of the aggregate instead: an
+// event whose predecessors were not handled yet fails with a Retryable error
+// and is handled again after them, see RetryPipeline.
+package kafka
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Config is the connection of a service to Kafka.
+type Config struct {
+	Brokers     []string `yaml:"brokers" env:"KAFKA_BROKERS" flag:"kafka-brokers" default:"localhost:9092" validate:"required"`
+	Partitioner string   `yaml:"partitioner" env:"KAFKA_PARTITIONER" default:"hash" validate:"oneof=hash reference crc32"`
+}
+
+// ConsumerConfig is how a service consumes its topics. ReplayPolicy is the
+// authentication policy of replays, see Authenticate. Retention is the
+// retention of the consumed topics.
+type ConsumerConfig struct {
+	GroupID      string        `yaml:"groupId" env:"KAFKA_GROUP_ID" flag:"group-id" validate:"required"`
+	Retry        RetryPolicy   `yaml:"retry"`
+	ReplayPolicy string        `yaml:"replayPolicy" env:"EVENT_REPLAY_POLICY" default:"lenient" validate:"oneof=strict lenient"`
+	Retention    time.Duration `yaml:"retention" env:"KAFKA_TOPIC_RETENTION" default:"168h" validate:"required"`
+}
+
+// LedgerTTL is how long processed events are remembered: the retention of
+// the topics plus the delays of all retry stages, with a day to spare.
+func (c ConsumerConfig) LedgerTTL() time.Duration {
+	ttl := c.Retention + 24*time.Hour
+	for stage := 1; stage <= c.Retry.RetryTopics; stage++ {
+		ttl += c.Retry.RetryDelay * time.Duration(stage)
+	}
+	return ttl
+}
+
+// NewConfig returns the consumer group configuration of the services. The
+// range strategy hands the same partition of every subscribed topic to the
+// same member, so with co-partitioned topics one consumer sees all events of
+// an aggregate, and the ones waiting for their predecessors don't wait for
+// another consumer.
+func NewConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRange()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Version = sarama.V1_0_0_0
+	return config
+}
+
+// NewProducerConfig returns the producer configuration of the services. Only
+// one request is in flight per broker so a retried send can't overtake the
+// next one, which would break the per-key order.
+func NewProducerConfig(partitioner string) (*sarama.Config, error) {
+	constructor, err := Partitioner(partitioner)
+	if err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Producer.Partitioner = constructor
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Net.MaxOpenRequests = 1
+	config.Version = sarama.V1_0_0_0
+	return config, nil
+}
+
+// Partitioner returns the key hashing partitioner called name: "hash" (FNV-1a,
+// the sarama default), "reference" (FNV-1a with the Java client's handling of
+// negative hashes) or "crc32" (librdkafka's consistent partitioner). Random and
+// round-robin partitioners are refused as they ignore the key.
+func Partitioner(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case "hash":
+		return sarama.NewHashPartitioner, nil
+	case "reference":
+		return sarama.NewReferenceHashPartitioner, nil
+	case "crc32":
+		return sarama.NewConsistentCRCHashPartitioner, nil
+	}
+	return nil, fmt.Errorf("unknown kafka partitioner %q", name)
+}
+
+func NewSyncProducer(cfg Config) (sarama.SyncProducer, error) {
+	config, err := NewProducerConfig(cfg.Partitioner)
+	if err != nil {
+		return nil, err
+	}
+	return sarama.NewSyncProducer(cfg.Brokers, config)
+}
+
+// Copartitioned checks that the topics of each aggregate (product.created,
+// product.updated, ...) have the same number of partitions. Only then does an
+// aggregate id map to the same partition number on each of them, and the range
+// strategy assigns all of its events to the same consumer. The order of the
+// events does not depend on it, see the package documentation.
+func Copartitioned(brokers []string, topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	client, err := sarama.NewClient(brokers, NewConfig())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	first := map[string]string{}
+	counts := map[string]int{}
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("partitions of %s: %w", topic, err)
+		}
+		counts[topic] = len(partitions)
+
+		aggregate, _, _ := strings.Cut(topic, ".")
+		other, ok := first[aggregate]
+		if !ok {
+			first[aggregate] = topic
+			continue
+		}
+		if counts[topic] != counts[other] {
+			return fmt.Errorf("topic %s has %d partitions, %s has %d", topic, counts[topic], other, counts[other])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Ledger interface {
+	IsProcessed(ctx context.Context, eventID string) (bool, error)
+	MarkProcessed(ctx context.Context, eventID string, topic string) error
+}
+
+type ProcessedEvent struct {
+	EventID     string    `json:"eventId" bson:"eventId"`
+	Topic       string    `json:"topic" bson:"topic"`
+	ProcessedAt time.Time `json:"processedAt" bson:"processedAt"`
+}
+
+type ledger struct {
+	collection *mongo.Collection
+}
+
+func NewLedger(collection *mongo.Collection) Ledger {
+	return &ledger{collection}
+}
+
+// ledgerTTLIndex is the name of the index expiring processed events.
+const ledgerTTLIndex = "processedAt_ttl"
+
+// CreateLedgerIndexes creates the indexes of a ledger collection: a unique
+// event id and a TTL on processedAt, so the ledger doesn't grow without
+// bound. An event only has to be remembered while it can be delivered again,
+// so ttl must exceed that, see ConsumerConfig.LedgerTTL. A changed ttl is
+// applied to the existing index.
+func CreateLedgerIndexes(ctx context.Context, collection *mongo.Collection, ttl time.Duration) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "eventId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}, {
+		Keys:    bson.D{{Key: "processedAt", Value: 1}},
+		Options: options.Index().SetName(ledgerTTLIndex).SetExpireAfterSeconds(int32(ttl.Seconds())),
+	}})
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "IndexOptionsConflict" {
+		return collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.M{"name": ledgerTTLIndex, "expireAfterSeconds": int32(ttl.Seconds())}},
+		}).Err()
+	}
+	return err
+}
+
+func (l *ledger) IsProcessed(ctx context.Context, eventID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	count, err := l.collection.CountDocuments(ctx, bson.M{"eventId": eventID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (l *ledger) MarkProcessed(ctx context.Context, eventID string, topic string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := l.collection.InsertOne(ctx, ProcessedEvent{
+		EventID:     eventID,
+		Topic:       topic,
+		ProcessedAt: time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
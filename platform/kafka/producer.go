@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/IBM/sarama"
+	logger "github.com/sirupsen/logrus"
+)
+
+type EventProducer struct {
+	producer sarama.SyncProducer
+}
+
+func NewEventProducer(producer sarama.SyncProducer) *EventProducer {
+	return &EventProducer{producer}
+}
+
+func (e *EventProducer) Produce(topic, key string, event any) (err error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return e.Send(topic, key, value)
+}
+
+// Send publishes value on topic. key is the id of the aggregate the event
+// belongs to and decides the partition.
+func (e *EventProducer) Send(topic, key string, value []byte, headers ...sarama.RecordHeader) error {
+	msg := sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(key),
+		Value:   sarama.ByteEncoder(value),
+		Headers: headers,
+	}
+	partition, offset, err := e.producer.SendMessage(&msg)
+	if err != nil {
+		return err
+	}
+
+	logger.WithFields(logger.Fields{
+		"topic":     topic,
+		"key":       key,
+		"partition": partition,
+		"offset":    offset,
+		"event":     string(value),
+	}).Info("send message to kafka")
+
+	return nil
+}
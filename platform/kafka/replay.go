@@ -0,0 +1,553 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/signal"
+	"regexp"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReplayOptions are set with --replay --from=earliest --into=<suffix>
+// --max-skipped=<n>.
+type ReplayOptions struct {
+	From       string
+	Into       string
+	MaxSkipped int
+}
+
+// ReplayFlags registers --replay, --from, --into and --max-skipped.
+func ReplayFlags() (replay *bool, opts *ReplayOptions) {
+	opts = &ReplayOptions{}
+	replay = flag.Bool("replay", false, "rebuild the collections from the topics and swap them in")
+	flag.StringVar(&opts.From, "from", "earliest", "offset the replay starts from")
+	flag.StringVar(&opts.Into, "into", "", "suffix of the collections the replay writes to before the swap")
+	flag.IntVar(&opts.MaxSkipped, "max-skipped", 100, "number of poison events the replay skips before it fails")
+	return replay, opts
+}
+
+var suffixPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func (o ReplayOptions) Validate() error {
+	if o.From != "earliest" {
+		return fmt.Errorf("replay: unsupported --from %q, only earliest is supported", o.From)
+	}
+	if !suffixPattern.MatchString(o.Into) {
+		return fmt.Errorf("replay: --into must be a non-empty collection suffix of letters, digits and _")
+	}
+	if o.MaxSkipped < 0 {
+		return fmt.Errorf("replay: --max-skipped must not be negative")
+	}
+	return nil
+}
+
+// Shadow names the copy of collection a replay writes to.
+func Shadow(collection, suffix string) string {
+	if suffix == "" {
+		return collection
+	}
+	return collection + "_" + suffix
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// Replay rebuilds Collections from Topics. It consumes every partition from
+// the earliest offset up to the offset it had when the replay started, with
+// a fresh consumer group and into the collections suffixed with opts.Into,
+// then renames these over the live ones. Events the live consumer handled
+// meanwhile went to the replaced collections, so the replay finally catches
+// up on them in the live ones.
+//
+// Each rename is atomic but the swap as a whole is not: until the last
+// collection is renamed, readers see rebuilt collections next to stale ones.
+// The swap is recorded before the first rename, see swapRecord, so a replay
+// that stops before it caught up leaves the record behind: live consumers
+// refuse to start, see CheckSwap, and running the replay again with the same
+// --into finishes the renames and the catch up instead of starting over.
+type Replay struct {
+	Brokers     []string
+	GroupID     string
+	Topics      []string
+	DB          *mongo.Database
+	Collections []string
+	Policy      RetryPolicy
+	Logger      *logrus.Logger
+	// Indexes creates the indexes of the collections named with suffix.
+	Indexes func(db *mongo.Database, suffix string)
+	// Handler handles events on the collections named with suffix.
+	Handler func(suffix string) EventHandler
+}
+
+func (r Replay) Run(opts ReplayOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	record, err := findSwap(ctx, r.DB)
+	if err != nil {
+		return err
+	}
+	if record != nil && record.Suffix != opts.Into {
+		return record.unfinished()
+	}
+
+	client, err := sarama.NewClient(r.Brokers, NewConfig())
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	defer client.Close()
+
+	groupID := fmt.Sprintf("%s.replay.%s.%d", r.GroupID, opts.Into, time.Now().Unix())
+	if record != nil {
+		groupID = record.GroupID
+	}
+	group, err := sarama.NewConsumerGroupFromClient(groupID, client)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	defer group.Close()
+
+	if record != nil {
+		r.Logger.WithFields(logrus.Fields{
+			"group":   groupID,
+			"into":    opts.Into,
+			"swapped": record.Swapped,
+			"started": record.StartedAt,
+		}).Warn("replay resumes an unfinished swap")
+		return r.finish(ctx, client, group, record, opts.MaxSkipped)
+	}
+
+	if err := r.prepareShadow(ctx, opts.Into); err != nil {
+		return err
+	}
+
+	start, err := partitionOffsets(client, r.Topics, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	marks, err := partitionOffsets(client, r.Topics, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+
+	r.Logger.WithFields(logrus.Fields{"group": groupID, "into": opts.Into, "topics": r.Topics}).Info("replay started")
+	if err := r.until(ctx, group, r.Handler(opts.Into), pending(start, marks), opts.MaxSkipped); err != nil {
+		return err
+	}
+
+	record = newSwapRecord(opts.Into, groupID, r.Collections, marks)
+	if err := r.beginSwap(ctx, record); err != nil {
+		return err
+	}
+	return r.finish(ctx, client, group, record, opts.MaxSkipped)
+}
+
+// finish swaps the collections of record in and catches up on the events
+// after its marks in the live ones. Only then the record is removed.
+func (r Replay) finish(ctx context.Context, client sarama.Client, group sarama.ConsumerGroup, record *swapRecord, maxSkipped int) error {
+	if err := r.swap(ctx, record); err != nil {
+		return err
+	}
+
+	marks := record.marks()
+	latest, err := partitionOffsets(client, r.Topics, sarama.OffsetNewest)
+	if err != nil {
+		return errors.Join(err, record.unfinished())
+	}
+	if err := r.until(ctx, group, r.Handler(""), pending(marks, latest), maxSkipped); err != nil {
+		return errors.Join(err, record.unfinished())
+	}
+	if err := r.endSwap(ctx); err != nil {
+		return err
+	}
+
+	r.Logger.WithFields(logrus.Fields{"group": record.GroupID, "into": record.Suffix}).Info("replay completed")
+	return nil
+}
+
+// prepareShadow empties the shadow collections and indexes them like the
+// live ones.
+func (r Replay) prepareShadow(ctx context.Context, suffix string) error {
+	for _, name := range r.Collections {
+		shadow := Shadow(name, suffix)
+		if err := r.DB.Collection(shadow).Drop(ctx); err != nil {
+			return fmt.Errorf("replay: drop %s: %w", shadow, err)
+		}
+		// created even when the replay writes nothing to it, so the swap
+		// replaces the live collection with an empty one
+		if err := r.DB.CreateCollection(ctx, shadow); err != nil {
+			return fmt.Errorf("replay: create %s: %w", shadow, err)
+		}
+	}
+	r.Indexes(r.DB, suffix)
+	return nil
+}
+
+// until consumes the topics until every partition in marks has been handled
+// up to its mark, then applies the events still waiting for their
+// predecessors, see replayHandler.
+func (r Replay) until(ctx context.Context, group sarama.ConsumerGroup, handler EventHandler, marks map[topicPartition]int64, maxSkipped int) error {
+	if len(marks) == 0 {
+		return nil
+	}
+
+	consumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	h := &replayHandler{
+		handler:    handler,
+		policy:     r.Policy,
+		logger:     r.Logger,
+		done:       cancel,
+		maxSkipped: maxSkipped,
+		marks:      marks,
+		waiting:    map[waitKey][]*sarama.ConsumerMessage{},
+	}
+	for consumeCtx.Err() == nil {
+		if err := group.Consume(consumeCtx, r.Topics, h); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+	}
+	if err := h.result(); err != nil {
+		return err
+	}
+	if err := h.finish(ctx); err != nil {
+		return err
+	}
+
+	r.Logger.WithFields(logrus.Fields{"skipped": h.skipped}).Info("replay pass completed")
+	return nil
+}
+
+// partitionOffsets returns the offset at (oldest or newest) of every
+// partition of topics.
+func partitionOffsets(client sarama.Client, topics []string, at int64) (map[topicPartition]int64, error) {
+	offsets := map[topicPartition]int64{}
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("replay: %s: %w", topic, err)
+		}
+		for _, partition := range partitions {
+			offset, err := client.GetOffset(topic, partition, at)
+			if err != nil {
+				return nil, fmt.Errorf("replay: %s/%d: %w", topic, partition, err)
+			}
+			offsets[topicPartition{topic, partition}] = offset
+		}
+	}
+	return offsets, nil
+}
+
+// pending returns the marks of the partitions with messages after from.
+func pending(from, marks map[topicPartition]int64) map[topicPartition]int64 {
+	result := map[topicPartition]int64{}
+	for tp, mark := range marks {
+		if mark > from[tp] {
+			result[tp] = mark
+		}
+	}
+	return result
+}
+
+// replayHandler handles messages up to the marks and calls done once all of
+// them are reached. The topics are consumed concurrently, so an event may
+// arrive before the event of another topic it depends on, such as an update
+// before the creation of its product. An event failing with a retryable error
+// waits, together with the later events of its key and topic, until another
+// event of its key was applied and is tried again then, and finally once all
+// marks are reached, see finish. Poison messages are skipped as the live consumer parked them
+// too, up to maxSkipped; any other failure stops the replay before anything
+// is swapped.
+type replayHandler struct {
+	handler    EventHandler
+	policy     RetryPolicy
+	logger     *logrus.Logger
+	done       context.CancelFunc
+	maxSkipped int
+
+	mu      sync.Mutex
+	marks   map[topicPartition]int64
+	err     error
+	skipped int
+	// waiting holds the messages waiting per key and topic, in the order they
+	// arrived, keys these in the order they started to wait.
+	waiting map[waitKey][]*sarama.ConsumerMessage
+	keys    []waitKey
+	lastErr error
+
+	retrying sync.Mutex
+}
+
+func (h *replayHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *replayHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *replayHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tp := topicPartition{claim.Topic(), claim.Partition()}
+	for msg := range claim.Messages() {
+		mark, ok := h.mark(tp)
+		if !ok || msg.Offset >= mark {
+			return nil
+		}
+
+		if err := h.handle(session.Context(), msg); err != nil {
+			h.fail(err)
+			return err
+		}
+		session.MarkMessage(msg, "")
+
+		if msg.Offset+1 >= mark {
+			h.reached(tp)
+			return nil
+		}
+	}
+	return nil
+}
+
+// handle applies msg unless earlier events of its key and topic are waiting,
+// and retries the waiting events of its key once it was applied.
+func (h *replayHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	if h.waitBehind(msg) {
+		return nil
+	}
+
+	err := h.handler.Handle(ctx, msg)
+	switch {
+	case err == nil && len(msg.Key) == 0:
+		return nil
+	case err == nil:
+		_, err = h.retry(ctx, string(msg.Key))
+		return err
+	case IsPoison(err):
+		return h.skip(msg, err)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+	h.wait(msg, err)
+	return nil
+}
+
+// finish retries the waiting events until none is left, and fails when they
+// made no progress in policy.MaxAttempts rounds.
+func (h *replayHandler) finish(ctx context.Context) error {
+	for attempt := 1; h.pending() > 0; attempt++ {
+		progress, err := h.retry(ctx, "")
+		if err != nil {
+			return err
+		}
+		if progress {
+			attempt = 0
+			continue
+		}
+		if attempt >= max(h.policy.MaxAttempts, 1) {
+			return fmt.Errorf("replay: %d events still wait for their predecessors: %w", h.pending(), h.lastErr)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.policy.Backoff * time.Duration(attempt)):
+		}
+	}
+	return nil
+}
+
+// retry tries the waiting events of key, of every key when it is empty, in
+// order until the first one that still fails, as long as any of them is
+// applied. Only one caller retries at a time; the others carry on.
+func (h *replayHandler) retry(ctx context.Context, only string) (progress bool, err error) {
+	if !h.retrying.TryLock() {
+		return false, nil
+	}
+	defer h.retrying.Unlock()
+
+	for applied := true; applied; {
+		applied = false
+		for _, key := range h.waitingKeys(only) {
+			for msg := h.next(key); msg != nil; msg = h.next(key) {
+				err := h.handler.Handle(ctx, msg)
+				if err != nil && !IsPoison(err) {
+					if ctx.Err() != nil {
+						return progress, ctx.Err()
+					}
+					h.setLastErr(msg, err)
+					break
+				}
+				if err != nil {
+					if err := h.skip(msg, err); err != nil {
+						return progress, err
+					}
+				}
+				h.pop(key)
+				applied, progress = true, true
+			}
+		}
+	}
+	return progress, nil
+}
+
+// skip counts the poison message and fails once more than maxSkipped were
+// skipped.
+func (h *replayHandler) skip(msg *sarama.ConsumerMessage, err error) error {
+	h.mu.Lock()
+	h.skipped++
+	skipped := h.skipped
+	h.mu.Unlock()
+
+	h.logger.WithFields(logrus.Fields{
+		"topic":     msg.Topic,
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+		"skipped":   skipped,
+		"error":     err,
+	}).Warn("replay skips poison message")
+	if skipped > h.maxSkipped {
+		return fmt.Errorf("replay: skipped %d poison events, more than %d: %w", skipped, h.maxSkipped, err)
+	}
+	return nil
+}
+
+// waitKey groups the waiting messages that have to be applied in order.
+type waitKey struct {
+	key   string
+	topic string
+}
+
+func replayKey(msg *sarama.ConsumerMessage) waitKey {
+	if len(msg.Key) == 0 {
+		// unrelated to any other message
+		return waitKey{fmt.Sprintf("%d@%d", msg.Partition, msg.Offset), msg.Topic}
+	}
+	return waitKey{string(msg.Key), msg.Topic}
+}
+
+// waitBehind queues msg when earlier messages of its key are waiting.
+func (h *replayHandler) waitBehind(msg *sarama.ConsumerMessage) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := replayKey(msg)
+	if len(h.waiting[key]) == 0 {
+		return false
+	}
+	h.waiting[key] = append(h.waiting[key], msg)
+	return true
+}
+
+func (h *replayHandler) wait(msg *sarama.ConsumerMessage, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := replayKey(msg)
+	if len(h.waiting[key]) == 0 {
+		h.keys = append(h.keys, key)
+	}
+	h.waiting[key] = append(h.waiting[key], msg)
+	h.lastErr = fmt.Errorf("%s/%d@%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+}
+
+func (h *replayHandler) setLastErr(msg *sarama.ConsumerMessage, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = fmt.Errorf("%s/%d@%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+}
+
+func (h *replayHandler) waitingKeys(only string) []waitKey {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if only == "" {
+		return slices.Clone(h.keys)
+	}
+	var keys []waitKey
+	for _, key := range h.keys {
+		if key.key == only {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// next returns the first waiting message of key, nil when there is none.
+func (h *replayHandler) next(key waitKey) *sarama.ConsumerMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.waiting[key]) == 0 {
+		return nil
+	}
+	return h.waiting[key][0]
+}
+
+func (h *replayHandler) pop(key waitKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.waiting[key] = h.waiting[key][1:]
+	if len(h.waiting[key]) == 0 {
+		delete(h.waiting, key)
+		h.keys = slices.DeleteFunc(h.keys, func(k waitKey) bool { return k == key })
+	}
+}
+
+func (h *replayHandler) pending() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, msgs := range h.waiting {
+		n += len(msgs)
+	}
+	return n
+}
+
+func (h *replayHandler) mark(tp topicPartition) (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	mark, ok := h.marks[tp]
+	return mark, ok
+}
+
+func (h *replayHandler) reached(tp topicPartition) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.marks, tp)
+	if len(h.marks) == 0 {
+		h.done()
+	}
+}
+
+func (h *replayHandler) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.done()
+}
+
+// result is nil once all marks were reached.
+func (h *replayHandler) result() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return h.err
+	}
+	if len(h.marks) != 0 {
+		return fmt.Errorf("replay: interrupted before reaching the end of %d partitions", len(h.marks))
+	}
+	return nil
+}
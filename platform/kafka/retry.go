@@ -0,0 +1,194 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderFailureReason     = "x-failure-reason"
+	HeaderRetryCount        = "x-retry-count"
+	HeaderRetryAfter        = "x-retry-after"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// RetryPolicy controls what happens to a message its handler fails on: it is
+// retried MaxAttempts times in-process, then forwarded through RetryTopics
+// stages (<topic>.retry.1 .. <topic>.retry.N) and finally to <topic>.dlq.
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"maxAttempts" env:"CONSUMER_MAX_ATTEMPTS" default:"3" validate:"required"`
+	Backoff     time.Duration `yaml:"backoff" env:"CONSUMER_RETRY_BACKOFF" default:"200ms"`
+	RetryTopics int           `yaml:"retryTopics" env:"CONSUMER_RETRY_TOPICS" default:"2"`
+	RetryDelay  time.Duration `yaml:"retryDelay" env:"CONSUMER_RETRY_DELAY" default:"30s"`
+}
+
+// Topics returns the given topics together with their retry stages.
+func (p RetryPolicy) Topics(topics []string) []string {
+	result := append([]string{}, topics...)
+	for _, topic := range topics {
+		for stage := 1; stage <= p.RetryTopics; stage++ {
+			result = append(result, RetryTopic(topic, stage))
+		}
+	}
+	return result
+}
+
+func RetryTopic(topic string, stage int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, stage)
+}
+
+func DeadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// parseTopic splits a retry topic into its original topic and retry stage.
+func parseTopic(topic string) (string, int) {
+	i := strings.LastIndex(topic, ".retry.")
+	if i < 0 {
+		return topic, 0
+	}
+	stage, err := strconv.Atoi(topic[i+len(".retry."):])
+	if err != nil {
+		return topic, 0
+	}
+	return topic[:i], stage
+}
+
+type RetryPipeline struct {
+	policy   RetryPolicy
+	producer sarama.SyncProducer
+	logger   *logrus.Logger
+}
+
+func NewRetryPipeline(policy RetryPolicy, producer sarama.SyncProducer, logger *logrus.Logger) *RetryPipeline {
+	return &RetryPipeline{policy, producer, logger}
+}
+
+// Process runs handler for msg. The handler sees the original topic, so retry
+// stages are handled the same way as the first delivery. A nil error means the
+// message was either handled or parked on a retry/dead-letter topic and its
+// offset can be committed.
+func (p *RetryPipeline) Process(ctx context.Context, msg *sarama.ConsumerMessage, handler EventHandler) error {
+	topic, stage := parseTopic(msg.Topic)
+	if stage > 0 {
+		if err := p.waitRetryAfter(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	original := *msg
+	original.Topic = topic
+
+	maxAttempts := max(p.policy.MaxAttempts, 1)
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = handler.Handle(ctx, &original); err == nil {
+			return nil
+		}
+		if IsPoison(err) {
+			p.logger.WithFields(logrus.Fields{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"error":     err,
+			}).Error("poison message")
+			return p.forward(msg, topic, p.policy.RetryTopics+1, err)
+		}
+
+		p.logger.WithFields(logrus.Fields{
+			"topic":     msg.Topic,
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"attempt":   attempt,
+			"error":     err,
+		}).Warn("handle message failed")
+
+		if attempt < maxAttempts {
+			if err := sleep(ctx, p.policy.Backoff*time.Duration(1<<(attempt-1))); err != nil {
+				return err
+			}
+		}
+	}
+	return p.forward(msg, topic, stage+1, err)
+}
+
+func (p *RetryPipeline) forward(msg *sarama.ConsumerMessage, topic string, stage int, reason error) error {
+	target := DeadLetterTopic(topic)
+	if stage <= p.policy.RetryTopics {
+		target = RetryTopic(topic, stage)
+	}
+
+	headers := Headers(msg)
+	// keep the position of the very first delivery across retry stages
+	if _, ok := headers[HeaderOriginalOffset]; !ok {
+		headers[HeaderOriginalTopic] = topic
+		headers[HeaderOriginalPartition] = strconv.Itoa(int(msg.Partition))
+		headers[HeaderOriginalOffset] = strconv.FormatInt(msg.Offset, 10)
+	}
+
+	now := time.Now().UTC()
+	headers[HeaderFailureReason] = reason.Error()
+	headers[HeaderRetryCount] = strconv.Itoa(stage - 1)
+	headers[HeaderFailedAt] = now.Format(time.RFC3339Nano)
+	headers[HeaderRetryAfter] = now.Add(p.policy.RetryDelay * time.Duration(stage)).Format(time.RFC3339Nano)
+
+	record := &sarama.ProducerMessage{
+		Topic:   target,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: RecordHeaders(headers),
+	}
+
+	partition, offset, err := p.producer.SendMessage(record)
+	if err != nil {
+		return fmt.Errorf("forward to %s: %w", target, err)
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"topic":     msg.Topic,
+		"target":    target,
+		"partition": partition,
+		"offset":    offset,
+		"reason":    reason.Error(),
+	}).Error("message forwarded")
+	return nil
+}
+
+func (p *RetryPipeline) waitRetryAfter(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	for _, h := range msg.Headers {
+		if h == nil || string(h.Key) != HeaderRetryAfter {
+			continue
+		}
+		retryAfter, err := time.Parse(time.RFC3339Nano, string(h.Value))
+		if err != nil {
+			return nil
+		}
+		return sleep(ctx, time.Until(retryAfter))
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
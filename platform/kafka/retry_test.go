@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingProducer keeps the messages sent with it.
+type recordingProducer struct {
+	sarama.SyncProducer
+	messages []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.messages = append(p.messages, msg)
+	return 0, int64(len(p.messages) - 1), nil
+}
+
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func recordHeaders(msg *sarama.ProducerMessage) map[string]string {
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	return headers
+}
+
+func TestRetryPolicyTopics(t *testing.T) {
+	got := RetryPolicy{RetryTopics: 2}.Topics([]string{"a", "b"})
+	want := []string{"a", "b", "a.retry.1", "a.retry.2", "b.retry.1", "b.retry.2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Topics() = %v, want %v", got, want)
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	cases := []struct {
+		topic string
+		want  string
+		stage int
+	}{
+		{"product.created", "product.created", 0},
+		{"product.created.retry.1", "product.created", 1},
+		{"product.created.retry.12", "product.created", 12},
+		{"product.created.retry.x", "product.created.retry.x", 0},
+		{"product.created.dlq", "product.created.dlq", 0},
+	}
+	for _, tc := range cases {
+		if topic, stage := parseTopic(tc.topic); topic != tc.want || stage != tc.stage {
+			t.Errorf("parseTopic(%q) = %q, %d, want %q, %d", tc.topic, topic, stage, tc.want, tc.stage)
+		}
+	}
+}
+
+func TestRetryPipelineProcess(t *testing.T) {
+	failure := errors.New("connection refused")
+	cases := []struct {
+		name       string
+		topic      string
+		err        error
+		attempts   int
+		target     string
+		retryCount string
+	}{
+		{name: "handled", topic: "t", attempts: 1},
+		{name: "handled on a retry stage", topic: "t.retry.2", attempts: 1},
+		{name: "failing goes to the first stage", topic: "t", err: failure, attempts: 3, target: "t.retry.1", retryCount: "0"},
+		{name: "failing on a stage goes to the next one", topic: "t.retry.1", err: failure, attempts: 3, target: "t.retry.2", retryCount: "1"},
+		{name: "failing on the last stage is dead-lettered", topic: "t.retry.2", err: failure, attempts: 3, target: "t.dlq", retryCount: "2"},
+		{name: "poison is dead-lettered at once", topic: "t", err: Poison(failure), attempts: 1, target: "t.dlq", retryCount: "2"},
+		{name: "poison on a stage is dead-lettered at once", topic: "t.retry.1", err: Poison(failure), attempts: 1, target: "t.dlq", retryCount: "2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			producer := &recordingProducer{}
+			pipeline := NewRetryPipeline(RetryPolicy{MaxAttempts: 3, RetryTopics: 2}, producer, discardLogger())
+
+			var topics []string
+			handler := HandlerFunc(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				topics = append(topics, msg.Topic)
+				return tc.err
+			})
+			msg := &sarama.ConsumerMessage{Topic: tc.topic, Partition: 3, Offset: 7, Key: []byte("k"), Value: []byte("v")}
+			if err := pipeline.Process(context.Background(), msg, handler); err != nil {
+				t.Fatalf("Process() = %v", err)
+			}
+
+			if len(topics) != tc.attempts {
+				t.Errorf("handled %d times, want %d", len(topics), tc.attempts)
+			}
+			for _, topic := range topics {
+				if topic != "t" {
+					t.Errorf("handler saw topic %q, want the original topic", topic)
+				}
+			}
+
+			if tc.target == "" {
+				if len(producer.messages) != 0 {
+					t.Errorf("forwarded %d messages, want none", len(producer.messages))
+				}
+				return
+			}
+			if len(producer.messages) != 1 {
+				t.Fatalf("forwarded %d messages, want 1", len(producer.messages))
+			}
+			forwarded := producer.messages[0]
+			if forwarded.Topic != tc.target {
+				t.Errorf("forwarded to %q, want %q", forwarded.Topic, tc.target)
+			}
+			headers := recordHeaders(forwarded)
+			if headers[HeaderRetryCount] != tc.retryCount {
+				t.Errorf("%s = %q, want %q", HeaderRetryCount, headers[HeaderRetryCount], tc.retryCount)
+			}
+			if headers[HeaderFailureReason] != tc.err.Error() {
+				t.Errorf("%s = %q, want %q", HeaderFailureReason, headers[HeaderFailureReason], tc.err.Error())
+			}
+		})
+	}
+}
+
+func TestRetryPipelineKeepsTheFirstDelivery(t *testing.T) {
+	producer := &recordingProducer{}
+	pipeline := NewRetryPipeline(RetryPolicy{MaxAttempts: 1, RetryTopics: 2}, producer, discardLogger())
+	failing := HandlerFunc(func(context.Context, *sarama.ConsumerMessage) error { return errors.New("failed") })
+
+	msg := &sarama.ConsumerMessage{Topic: "t", Partition: 3, Offset: 7}
+	for i := 0; i < 3; i++ {
+		if err := pipeline.Process(context.Background(), msg, failing); err != nil {
+			t.Fatalf("Process() = %v", err)
+		}
+		forwarded := producer.messages[len(producer.messages)-1]
+		msg = &sarama.ConsumerMessage{Topic: forwarded.Topic, Partition: 0, Offset: int64(i)}
+		for key, value := range recordHeaders(forwarded) {
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+
+	var targets []string
+	for _, m := range producer.messages {
+		targets = append(targets, m.Topic)
+	}
+	if want := []string{"t.retry.1", "t.retry.2", "t.dlq"}; !slices.Equal(targets, want) {
+		t.Errorf("forwarded to %v, want %v", targets, want)
+	}
+
+	headers := recordHeaders(producer.messages[2])
+	if headers[HeaderOriginalTopic] != "t" || headers[HeaderOriginalPartition] != "3" || headers[HeaderOriginalOffset] != "7" {
+		t.Errorf("dead-lettered from %s/%s/%s, want t/3/7", headers[HeaderOriginalTopic], headers[HeaderOriginalPartition], headers[HeaderOriginalOffset])
+	}
+	if id := EventID(msg); id != "t/3/7" {
+		t.Errorf("EventID() = %q, want the position of the first delivery", id)
+	}
+}
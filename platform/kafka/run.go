@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// Run consumes topics and their retry topics with the consumer group of
+// consumer, handling the events with handler, until ctx is done or SIGINT or
+// SIGTERM is received. SIGUSR1 pauses and resumes the consumption. An error is
+// returned when the consumer can't be set up; errors of a running consumer
+// group, such as a failed rebalance, are logged and consumption resumes.
+func Run(ctx context.Context, cfg Config, consumer ConsumerConfig, topics []string, handler EventHandler, logger *logrus.Logger) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := Copartitioned(cfg.Brokers, topics); err != nil {
+		logger.WithFields(logrus.Fields{
+			"error":  err,
+			"topics": topics,
+		}).Warn("topics are not co-partitioned, events waiting for their predecessors on other consumers are delayed")
+	}
+
+	producer, err := NewSyncProducer(cfg)
+	if err != nil {
+		return fmt.Errorf("retry producer: %w", err)
+	}
+	defer producer.Close()
+
+	group, err := sarama.NewConsumerGroup(cfg.Brokers, consumer.GroupID, NewConfig())
+	if err != nil {
+		return fmt.Errorf("consumer group %s: %w", consumer.GroupID, err)
+	}
+	defer group.Close()
+
+	go togglePause(ctx, group, logger)
+
+	policy := consumer.Retry
+	consumerHandler := NewConsumerHandler(handler, NewRetryPipeline(policy, producer, logger), logger)
+	topics = policy.Topics(topics)
+
+	logger.WithFields(logrus.Fields{"group": consumer.GroupID, "topics": topics}).Info("consumer started")
+	for ctx.Err() == nil {
+		err := group.Consume(ctx, topics, consumerHandler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			break
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{"error": err, "group": consumer.GroupID}).Error("consumer group error")
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+
+	logger.WithFields(logrus.Fields{"group": consumer.GroupID}).Info("consumer stopped")
+	return nil
+}
+
+// togglePause pauses the consumption of group on SIGUSR1, and resumes it on
+// the next one.
+func togglePause(ctx context.Context, group sarama.ConsumerGroup, logger *logrus.Logger) {
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
+	defer signal.Stop(sigusr1)
+
+	paused := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigusr1:
+		}
+
+		if paused {
+			group.ResumeAll()
+			logger.Info("consumption resumed")
+		} else {
+			group.PauseAll()
+			logger.Info("consumption paused")
+		}
+		paused = !paused
+	}
+}
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrUnfinishedSwap means a replay stopped while it swapped its collections
+// in: some live collections may be rebuilt while others are still stale, and
+// the events the live consumer handled during the replay are not caught up
+// on. Running the replay again with the same --into finishes it.
+var ErrUnfinishedSwap = errors.New("unfinished replay swap")
+
+// swapCollection holds the swap a replay has in progress, under swapID, see
+// swapRecord.
+const (
+	swapCollection = "replaySwap"
+	swapID         = "replay"
+)
+
+// swapRecord is written before the first collection of a replay is renamed
+// and removed once the replay caught up on the live events, so a replay that
+// stopped in between is detected, see CheckSwap, and finished by the next
+// one.
+type swapRecord struct {
+	ID          string     `bson:"_id"`
+	Suffix      string     `bson:"suffix"`
+	GroupID     string     `bson:"groupId"`
+	Collections []string   `bson:"collections"`
+	Swapped     []string   `bson:"swapped"`
+	Marks       []swapMark `bson:"marks"`
+	StartedAt   time.Time  `bson:"startedAt"`
+	// resumed is set when the record was left by a replay that stopped.
+	resumed bool
+}
+
+// swapMark is the offset of a partition the replay consumed up to before
+// the swap.
+type swapMark struct {
+	Topic     string `bson:"topic"`
+	Partition int32  `bson:"partition"`
+	Offset    int64  `bson:"offset"`
+}
+
+func newSwapRecord(suffix, groupID string, collections []string, marks map[topicPartition]int64) *swapRecord {
+	record := &swapRecord{
+		ID:          swapID,
+		Suffix:      suffix,
+		GroupID:     groupID,
+		Collections: collections,
+		Swapped:     []string{},
+		StartedAt:   time.Now().UTC(),
+	}
+	for tp, offset := range marks {
+		record.Marks = append(record.Marks, swapMark{tp.topic, tp.partition, offset})
+	}
+	return record
+}
+
+func (s *swapRecord) marks() map[topicPartition]int64 {
+	marks := map[topicPartition]int64{}
+	for _, m := range s.Marks {
+		marks[topicPartition{m.Topic, m.Partition}] = m.Offset
+	}
+	return marks
+}
+
+func (s *swapRecord) unfinished() error {
+	return fmt.Errorf("%w: %d of %d collections swapped in from %q since %s, run the replay again with --into=%s",
+		ErrUnfinishedSwap, len(s.Swapped), len(s.Collections), s.Suffix, s.StartedAt.Format(time.RFC3339), s.Suffix)
+}
+
+// CheckSwap returns an error wrapping ErrUnfinishedSwap when a replay
+// stopped during its swap. A live consumer must not start then, as it would
+// write to the half swapped collections and skip the events the replay still
+// has to catch up on.
+func CheckSwap(ctx context.Context, db *mongo.Database) error {
+	record, err := findSwap(ctx, db)
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		return record.unfinished()
+	}
+	return nil
+}
+
+func findSwap(ctx context.Context, db *mongo.Database) (*swapRecord, error) {
+	var record swapRecord
+	err := db.Collection(swapCollection).FindOne(ctx, bson.M{"_id": swapID}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("replay: read swap: %w", err)
+	}
+	record.resumed = true
+	return &record, nil
+}
+
+// beginSwap stores record, and fails when another replay has a swap in
+// progress.
+func (r Replay) beginSwap(ctx context.Context, record *swapRecord) error {
+	_, err := r.DB.Collection(swapCollection).InsertOne(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		if other, err := findSwap(ctx, r.DB); err == nil && other != nil {
+			return other.unfinished()
+		}
+		return ErrUnfinishedSwap
+	}
+	if err != nil {
+		return fmt.Errorf("replay: record swap: %w", err)
+	}
+	return nil
+}
+
+// swap renames the shadow collections of record over the live ones that
+// aren't swapped yet. Each rename is atomic, the swap as a whole is not:
+// readers see the rebuilt collections appear one after the other. A failure
+// leaves the record in place.
+func (r Replay) swap(ctx context.Context, record *swapRecord) error {
+	admin := r.DB.Client().Database("admin")
+	for _, name := range record.Collections {
+		if slices.Contains(record.Swapped, name) {
+			continue
+		}
+
+		shadow := Shadow(name, record.Suffix)
+		err := admin.RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: r.DB.Name() + "." + shadow},
+			{Key: "to", Value: r.DB.Name() + "." + name},
+			{Key: "dropTarget", Value: true},
+		}).Err()
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" && record.resumed {
+			// renamed by the replay that stopped before it recorded so;
+			// prepareShadow creates every shadow, so it can't be missing
+			// otherwise
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("replay: rename %s to %s: %w", shadow, name, errors.Join(err, record.unfinished()))
+		}
+
+		_, err = r.DB.Collection(swapCollection).UpdateOne(ctx, bson.M{"_id": swapID}, bson.M{"$addToSet": bson.M{"swapped": name}})
+		if err != nil {
+			return fmt.Errorf("replay: record swap of %s: %w", name, errors.Join(err, record.unfinished()))
+		}
+		record.Swapped = append(record.Swapped, name)
+		r.Logger.WithFields(logrus.Fields{"from": shadow, "to": name}).Info("collection swapped")
+	}
+	return nil
+}
+
+// endSwap removes the record once the replay caught up on the live events.
+func (r Replay) endSwap(ctx context.Context) error {
+	if _, err := r.DB.Collection(swapCollection).DeleteOne(ctx, bson.M{"_id": swapID}); err != nil {
+		return fmt.Errorf("replay: remove swap record: %w", err)
+	}
+	return nil
+}
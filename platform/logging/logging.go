@@ -0,0 +1,40 @@
+// Package logging sets up the JSON logrus loggers of the services.
+package logging
+
+import (
+	"log"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	Level string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info"`
+}
+
+func (c Config) Validate() error {
+	_, err := logrus.ParseLevel(c.Level)
+	return err
+}
+
+// New returns a logger set up with Setup. What the standard library logger
+// writes goes to it as well.
+func New(cfg Config) *logrus.Logger {
+	logger := logrus.New()
+	Setup(logger, cfg)
+	log.SetOutput(logger.Writer())
+	return logger
+}
+
+// Setup makes logger write JSON to stdout at cfg.Level, info when it isn't
+// set.
+func Setup(logger *logrus.Logger, cfg Config) {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetOutput(os.Stdout)
+	logger.SetLevel(level)
+}
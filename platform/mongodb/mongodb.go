@@ -0,0 +1,42 @@
+// Package mongodb connects the services to MongoDB.
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Config is the connection to the database all services share.
+type Config struct {
+	URI      string `yaml:"uri" env:"MONGO_URI" flag:"mongo-uri" default:"mongodb://localhost:27017/my_app?authSource=admin" secret:"true"`
+	Database string `yaml:"database" env:"MONGO_DATABASE" default:"my_app" validate:"required"`
+}
+
+// Connect connects to cfg.URI and returns the shared database once the
+// primary answers.
+func Connect(cfg Config) (*mongo.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.URI))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, err
+	}
+
+	return client.Database(cfg.Database), nil
+}
+
+func Disconnect(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	return db.Client().Disconnect(ctx)
+}
@@ -1,14 +1,18 @@
 package category
 
 import (
+	"errors"
+
 	"github.com/sing3demons/go-product-service/microservice"
+	"github.com/sing3demons/go-product-service/query"
 )
 
 type ICategoryHandler interface {
 	FindCategories(c microservice.IContext)
 	FindOne(c microservice.IContext)
 	InsertProduct(c microservice.IContext)
-	// DeleteProduct(c microservice.IContext)
+	UpdateCategory(c microservice.IContext)
+	DeleteCategory(c microservice.IContext)
 }
 
 type categoryHandler struct {
@@ -22,6 +26,10 @@ func NewCategoryHandler(svc ICategoryService) ICategoryHandler {
 func (h *categoryHandler) FindOne(c microservice.IContext) {
 	category, err := h.svc.FindOne(c)
 	if err != nil {
+		if errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		if err.Error() == "mongo: no documents in result" {
 			c.Error(404, "Not Found", err)
 			return
@@ -35,6 +43,10 @@ func (h *categoryHandler) FindOne(c microservice.IContext) {
 func (h *categoryHandler) FindCategories(c microservice.IContext) {
 	categories, err := h.svc.FindAll(c)
 	if err != nil {
+		if errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
@@ -58,3 +70,40 @@ func (h *categoryHandler) InsertProduct(c microservice.IContext) {
 		"id":      id,
 	})
 }
+
+func (h *categoryHandler) UpdateCategory(c microservice.IContext) {
+	var req UpdateCategoryReq
+	if err := c.Body(&req); err != nil {
+		c.Error(400, "Bad Request", err)
+		return
+	}
+	id, err := h.svc.UpdateCategory(c, req)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			c.Error(404, "Not Found", err)
+			return
+		}
+		c.Error(500, "Internal Server Error", err)
+		return
+	}
+	c.JSON(200, map[string]string{
+		"message": "success",
+		"id":      id,
+	})
+}
+
+func (h *categoryHandler) DeleteCategory(c microservice.IContext) {
+	id, err := h.svc.DeleteCategory(c)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			c.Error(404, "Not Found", err)
+			return
+		}
+		c.Error(500, "Internal Server Error", err)
+		return
+	}
+	c.JSON(200, map[string]string{
+		"message": "success",
+		"id":      id,
+	})
+}
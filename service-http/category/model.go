@@ -4,11 +4,6 @@ import (
 	"time"
 )
 
-type Event struct {
-	Header any `json:"header"`
-	Body   any `json:"body"`
-}
-
 type CreateCategoryReq struct {
 	ID         string    `json:"id" bson:"id"`
 	Name       string    `json:"name" bson:"name"`
@@ -26,6 +21,11 @@ type UpdateCategoryReq struct {
 	Products   []Product `json:"products,omitempty" bson:"products,omitempty"`
 }
 
+type DeleteCategoryRequest struct {
+	ID         string    `json:"id" bson:"id"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
+}
+
 type Category struct {
 	ID         string    `json:"id" bson:"id"`
 	Name       string    `json:"name" bson:"name"`
@@ -1,6 +1,11 @@
 package category
 
 import (
+	"context"
+	"time"
+
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/resolver"
 	"github.com/sing3demons/go-product-service/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -8,104 +13,143 @@ import (
 )
 
 type ICategoryRepository interface {
-	FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]Category, int64, error)
-	FindOne(filter bson.M, findOptions *options.FindOneOptions) (*Category, error)
+	FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M, sel *query.Selection) ([]Category, int64, error)
+	FindOne(filter bson.M, findOptions *options.FindOneOptions, sel *query.Selection) (*Category, error)
 }
 
 type categoryRepository struct {
 	collection *mongo.Collection
+	products   *mongo.Collection
+	prices     *mongo.Collection
 }
 
-func NewCategoryRepository(collection *mongo.Collection) ICategoryRepository {
-	return &categoryRepository{collection}
+func NewCategoryRepository(db *mongo.Database) ICategoryRepository {
+	return &categoryRepository{
+		collection: db.Collection("category"),
+		products:   db.Collection("product"),
+		prices:     db.Collection("productPrice"),
+	}
 }
 
-func (r *categoryRepository) FindOne(filter bson.M, findOptions *options.FindOneOptions) (category *Category, err error) {
-	category, err = utils.GetOne[Category](r.collection, filter, findOptions)
+func (r *categoryRepository) FindOne(filter bson.M, findOptions *options.FindOneOptions, sel *query.Selection) (*Category, error) {
+	if projection := sel.Projection(); projection != nil {
+		findOptions.SetProjection(projection)
+	}
+	category, err := utils.GetOne[Category](r.collection, filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	var products []Product
-	if len(category.Products) != 0 {
-		for _, v := range category.Products {
-			p, err := utils.HttpGetClient[Product]("http://localhost:8080/product/" + v.ID)
-			if err != nil {
-				products = append(products, Product{
-					ID:   p.ID,
-					Type: p.Type,
+	categories, err := r.enrich([]Category{*category}, sel)
+	if err != nil {
+		return nil, err
+	}
+	return &categories[0], nil
+}
+
+func (r *categoryRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M, sel *query.Selection) ([]Category, int64, error) {
+	sel.Apply(findOptions)
+	result, total, err := utils.GetMultiWithTotal[Category](r.collection, filter, findOptions, after)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	categories, err := r.enrich(result, sel)
+	if err != nil {
+		return nil, 0, err
+	}
+	return categories, total, nil
+}
+
+// enrich resolves the products of every category, and their prices, with one
+// query per collection. Products that no longer resolve are dropped, and
+// without products expanded only their ids are returned.
+func (r *categoryRepository) enrich(result []Category, sel *query.Selection) ([]Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !sel.Expands("products") {
+		categories := []Category{}
+		for _, category := range result {
+			var items []Product
+			for _, v := range category.Products {
+				items = append(items, Product{
+					ID:   v.ID,
+					Type: "products",
+					Href: utils.Href("products", v.ID),
 				})
 			}
-			products = append(products, Product{
-				ID:           p.ID,
-				Type:         p.Type,
-				Href:         utils.Href(p.Type, p.ID),
-				Status:       p.Status,
-				Title:        p.Title,
-				Description:  p.Description,
-				Image:        p.Image,
-				ProductPrice: p.ProductPrice,
-				LastUpdate:   p.LastUpdate,
-			})
+			category.Href = utils.Href(category.Type, category.ID)
+			category.Products = items
+			categories = append(categories, category)
 		}
+		return categories, nil
 	}
 
-	result := Category{
-		ID:         category.ID,
-		Type:       category.Type,
-		Status:     category.Status,
-		Href:       utils.Href(category.Type, category.ID),
-		Name:       category.Name,
-		Products:   products,
-		LastUpdate: category.LastUpdate,
+	var productIDs []string
+	for _, category := range result {
+		for _, v := range category.Products {
+			productIDs = append(productIDs, v.ID)
+		}
 	}
 
-	return &result, nil
-}
+	products, err := resolver.NewLoader(resolver.FindByID[Product](r.products), func(p Product) string { return p.ID }).LoadMany(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
 
-func (r *categoryRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]Category, int64, error) {
-	categories := []Category{}
-	result, total, err := utils.GetMultiWithTotal[Category](r.collection, filter, findOptions)
+	var priceIDs []string
+	for _, p := range products {
+		for _, v := range p.ProductPrice {
+			priceIDs = append(priceIDs, v.ID)
+		}
+	}
+
+	prices, err := resolver.NewLoader(resolver.FindByID[ProductPrice](r.prices), func(p ProductPrice) string { return p.ID }).LoadMany(ctx, priceIDs)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
+	categories := []Category{}
 	for _, category := range result {
+		var items []Product
+		for _, v := range category.Products {
+			p, ok := products[v.ID]
+			if !ok {
+				continue
+			}
 
-		var products []Product
-		if len(category.Products) != 0 {
-			for _, v := range category.Products {
-				p, err := utils.HttpGetClient[Product]("http://localhost:8080/product/" + v.ID)
-				if err != nil {
-					products = append(products, Product{
-						ID:   p.ID,
-						Type: p.Type,
-					})
+			var productPrice []ProductPrice
+			for _, v := range p.ProductPrice {
+				if price, ok := prices[v.ID]; ok {
+					price.Href = utils.Href(price.Type, price.ID)
+					productPrice = append(productPrice, price)
 				}
-				products = append(products, Product{
-					ID:           p.ID,
-					Type:         p.Type,
-					Href:         utils.Href(p.Type, p.ID),
-					Status:       p.Status,
-					Title:        p.Title,
-					Description:  p.Description,
-					Image:        p.Image,
-					ProductPrice: p.ProductPrice,
-					LastUpdate:   p.LastUpdate,
-				})
 			}
+
+			items = append(items, Product{
+				ID:           p.ID,
+				Type:         p.Type,
+				Href:         utils.Href(p.Type, p.ID),
+				Status:       p.Status,
+				Title:        p.Title,
+				Description:  p.Description,
+				Image:        p.Image,
+				ProductPrice: productPrice,
+				LastUpdate:   p.LastUpdate,
+			})
 		}
+
 		categories = append(categories, Category{
 			ID:         category.ID,
 			Type:       category.Type,
 			Status:     category.Status,
 			Href:       utils.Href(category.Type, category.ID),
 			Name:       category.Name,
-			Products:   products,
+			Products:   items,
 			LastUpdate: category.LastUpdate,
 		})
-
 	}
 
-	return categories, total, nil
+	return categories, nil
 }
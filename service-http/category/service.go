@@ -2,12 +2,13 @@ package category
 
 import (
 	"fmt"
-	"math"
-	"strconv"
+	"regexp"
+	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/contracts"
 	"github.com/sing3demons/go-product-service/microservice"
-	"github.com/sing3demons/go-product-service/producer"
+	"github.com/sing3demons/go-product-service/outbox"
+	"github.com/sing3demons/go-product-service/query"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -15,33 +16,52 @@ import (
 	"github.com/sing3demons/go-product-service/utils"
 )
 
+var categoryQuery = query.Spec{
+	Fields: []string{"name", "status", "products", "lastUpdate"},
+	Expand: []string{"products"},
+}
+
+var categorySort = query.SortSpec{
+	"name":       "name",
+	"status":     "status",
+	"lastUpdate": "lastUpdate",
+}
+
 type ICategoryService interface {
 	FindAll(c microservice.IContext) (any, error)
-	FindOne(c microservice.IContext) (*Category, error)
+	FindOne(c microservice.IContext) (any, error)
 	// EventCreateProduct(c microservice.IContext, req CreateProductRequest) (string, error)
 	// EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (string, error)
 	// EventDeleteProduct(c microservice.IContext) (string, error)
 	CreateCategory(c microservice.IContext, req CreateCategoryReq) (string, error)
+	UpdateCategory(c microservice.IContext, req UpdateCategoryReq) (string, error)
+	DeleteCategory(c microservice.IContext) (string, error)
 }
 type categoryService struct {
-	r        ICategoryRepository
-	producer sarama.SyncProducer
+	r      ICategoryRepository
+	outbox outbox.IOutbox
 }
 
-func NewCategoryService(r ICategoryRepository, producer sarama.SyncProducer) ICategoryService {
-	return &categoryService{r, producer}
+func NewCategoryService(r ICategoryRepository, outbox outbox.IOutbox) ICategoryService {
+	return &categoryService{r, outbox}
 }
 
 func (s *categoryService) FindAll(c microservice.IContext) (any, error) {
+	sel, err := categoryQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
+	if err != nil {
+		return nil, err
+	}
+
 	filter := bson.M{}
 	findOptions := options.Find()
 	if s := c.QueryString("s"); s != "" {
+		pattern := regexp.QuoteMeta(s)
 		filter = bson.M{
 			"$or": []bson.M{
 				{
 					"title": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
@@ -49,7 +69,7 @@ func (s *categoryService) FindAll(c microservice.IContext) (any, error) {
 				{
 					"description": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
@@ -58,57 +78,58 @@ func (s *categoryService) FindAll(c microservice.IContext) (any, error) {
 		}
 	}
 
-	if sort := c.QueryString("sort"); sort != "" {
-		if sort == "asc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: 1}})
-		} else if sort == "desc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: -1}})
-		}
+	sort, err := categorySort.Parse(c.QueryString("sort"))
+	if err != nil {
+		return nil, err
 	}
 
-	p := c.QueryString("page")
-	if p == "" {
-		p = "1"
+	page, err := query.ParsePage(c.QueryString("limit"), c.QueryString("cursor"), c.QueryString("page"), sort)
+	if err != nil {
+		return nil, err
 	}
+	page.Apply(findOptions)
+	sel.Require(page.Fields()...)
 
-	page, _ := strconv.Atoi(p)
-	if page == 0 {
-		page = 1
+	categories, total, err := s.r.FindAndTotal(filter, findOptions, page.After(), sel)
+	if err != nil {
+		return nil, err
 	}
-	var perPage int64 = 9
-
-	findOptions.SetSkip((int64(page) - 1) * perPage)
-	findOptions.SetLimit(perPage)
 
-	categories, total, err := s.r.FindAndTotal(filter, findOptions)
+	categories, next, err := query.Cut(page, categories)
 	if err != nil {
 		return nil, err
 	}
 
-	response := map[string]any{
-		"data":      categories,
-		"total":     total,
-		"page":      page,
-		"last_page": int64(math.Ceil(float64(total) / float64(perPage))),
+	data, err := sel.Trim(categories)
+	if err != nil {
+		return nil, err
 	}
 
+	response, link := page.Envelope(data, total, next, c.URL())
+	c.SetHeader("Link", link)
+
 	return response, nil
 }
 
-func (s *categoryService) FindOne(c microservice.IContext) (*Category, error) {
+func (s *categoryService) FindOne(c microservice.IContext) (any, error) {
 	id := c.Param("id")
 	if id == "" {
 		return nil, fmt.Errorf("id is required")
 	}
+	sel, err := categoryQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
+	if err != nil {
+		return nil, err
+	}
+
 	filter := bson.M{"id": id, "deleteDate": nil}
 	findOptions := options.FindOneOptions{}
-	category, err := s.r.FindOne(filter, &findOptions)
+	category, err := s.r.FindOne(filter, &findOptions, sel)
 
 	if err != nil {
 		return nil, err
 
 	}
-	return category, nil
+	return sel.Trim(category)
 }
 
 func (s *categoryService) CreateCategory(c microservice.IContext, req CreateCategoryReq) (string, error) {
@@ -117,25 +138,22 @@ func (s *categoryService) CreateCategory(c microservice.IContext, req CreateCate
 		return "", err
 	}
 
+	// lastUpdate orders the versions of the category in product views, so it
+	// is stamped here and never taken from the caller.
 	document := CreateCategoryReq{
-		ID:     id,
-		Name:   req.Name,
-		Type:   "category",
-		Status: req.Status,
-	}
-
-	if !req.LastUpdate.IsZero() {
-		document.LastUpdate = req.LastUpdate
+		ID:         id,
+		Name:       req.Name,
+		Type:       "category",
+		Status:     req.Status,
+		LastUpdate: time.Now().UTC(),
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   document,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.CategoryCreated, id, header, document)
+	if err != nil {
+		return "", err
 	}
-
-	eventProducer := producer.NewEventProducer(s.producer)
-	if err := eventProducer.Produce("category.created", data); err != nil {
+	if err := s.outbox.Publish(id, msg); err != nil {
 		return "", err
 	}
 
@@ -143,14 +161,21 @@ func (s *categoryService) CreateCategory(c microservice.IContext, req CreateCate
 }
 
 func (s *categoryService) UpdateCategory(c microservice.IContext, req UpdateCategoryReq) (string, error) {
-	id, err := utils.RandomNanoID(11)
-	if err != nil {
+	id := c.Param("id")
+	if id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+
+	if _, err := s.r.FindOne(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{}, query.All()); err != nil {
 		return "", err
 	}
 
-	var document UpdateCategoryReq
-	if !req.LastUpdate.IsZero() {
-		document.LastUpdate = req.LastUpdate
+	document := UpdateCategoryReq{
+		ID:         id,
+		Type:       "category",
+		Name:       req.Name,
+		Status:     req.Status,
+		LastUpdate: time.Now().UTC(),
 	}
 
 	if len(req.Products) != 0 {
@@ -166,14 +191,39 @@ func (s *categoryService) UpdateCategory(c microservice.IContext, req UpdateCate
 		}
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   document,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.CategoryUpdated, id, header, document)
+	if err != nil {
+		return "", err
+	}
+	if err := s.outbox.Publish(id, msg); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *categoryService) DeleteCategory(c microservice.IContext) (string, error) {
+	id := c.Param("id")
+	if id == "" {
+		return "", fmt.Errorf("id is required")
 	}
 
-	eventProducer := producer.NewEventProducer(s.producer)
-	if err := eventProducer.Produce("category.updated", data); err != nil {
+	if _, err := s.r.FindOne(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{}, query.All()); err != nil {
+		return "", err
+	}
+
+	document := DeleteCategoryRequest{
+		ID:         id,
+		DeleteDate: time.Now().UTC(),
+	}
+
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.CategoryDeleted, id, header, document)
+	if err != nil {
+		return "", err
+	}
+	if err := s.outbox.Publish(id, msg); err != nil {
 		return "", err
 	}
 
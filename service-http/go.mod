@@ -6,9 +6,9 @@ require (
 	github.com/IBM/sarama v1.42.1
 	github.com/aidarkhanov/nanoid/v2 v2.0.5
 	github.com/gin-gonic/gin v1.9.1
-	github.com/golang-jwt/jwt/v5 v5.1.0
+	github.com/golang-jwt/jwt/v5 v5.1.0 // indirect
 	github.com/google/uuid v1.5.0
-	github.com/joho/godotenv v1.5.1
+	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/sirupsen/logrus v1.9.3
 	go.mongodb.org/mongo-driver v1.13.0
 )
@@ -63,3 +63,7 @@ require (
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+require github.com/sing3demons/go-platform v0.0.0
+
+replace github.com/sing3demons/go-platform => ../platform
@@ -1,78 +1,81 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/url"
 	"os"
 
-	"github.com/IBM/sarama"
-	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 
+	"github.com/sing3demons/go-platform/auth"
+	"github.com/sing3demons/go-platform/config"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/logging"
+	"github.com/sing3demons/go-platform/mongodb"
 	"github.com/sing3demons/go-product-service/category"
-	"github.com/sing3demons/go-product-service/db"
 	"github.com/sing3demons/go-product-service/microservice"
+	"github.com/sing3demons/go-product-service/outbox"
 	"github.com/sing3demons/go-product-service/price"
 	"github.com/sing3demons/go-product-service/product"
 	"github.com/sing3demons/go-product-service/utils"
 )
 
-func init() {
-	if os.Getenv("GIN_MODE") != gin.ReleaseMode {
-		godotenv.Load(".env")
-	}
-
-	logLevel, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL"))
-	if err != nil {
-		logLevel = logrus.InfoLevel
-	}
-
-	logrus.SetLevel(logLevel)
-	logrus.SetFormatter(&logrus.JSONFormatter{})
-
-	// setup gin
-	mode := os.Getenv("GIN_MODE")
-	if mode == "" {
-		mode = gin.DebugMode
-	}
-
-	gin.SetMode(mode)
+// Config is the configuration of the service, see config.Load.
+type Config struct {
+	HTTP  microservice.Config `yaml:"http"`
+	Log   logging.Config      `yaml:"log"`
+	Mongo mongodb.Config      `yaml:"mongo"`
+	Kafka kafka.Config        `yaml:"kafka"`
+	Auth  auth.SignerConfig   `yaml:"auth"`
+	Users auth.UserConfig     `yaml:"users"`
 }
 
-func NewSyncProducer(kafkaBrokers []string) (sarama.SyncProducer, error) {
-	producer, err := sarama.NewSyncProducer(kafkaBrokers, nil)
-	if err != nil {
-		return nil, err
-	}
+func main() {
+	var cfg Config
+	config.Load(&cfg)
 
-	return producer, nil
-}
+	logging.Setup(logrus.StandardLogger(), cfg.Log)
+	auth.ConfigureSigner(cfg.Auth)
+	auth.ConfigureUsers(cfg.Users)
+	utils.SetHostURL(cfg.HTTP.HostURL)
 
-func main() {
 	_, err := os.Create("/tmp/live")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer os.Remove("/tmp/live")
-	db := db.NewMongoDB()
-
-	broker := os.Getenv("KAFKA_BROKERS")
-	if broker == "" {
-		broker = "localhost:9092"
+	db, err := mongodb.Connect(cfg.Mongo)
+	if err != nil {
+		panic("failed to connect database")
 	}
-	kafkaBrokers := []string{broker}
-	producer, err := NewSyncProducer(kafkaBrokers)
+
+	producer, err := kafka.NewSyncProducer(cfg.Kafka)
 	if err != nil {
 		panic(err)
 	}
 	defer producer.Close()
 
-	productRepository := product.NewProductRepository(db.Collection("product"))
-	productService := product.NewProductService(productRepository, producer)
+	outboxRepository, err := outbox.NewOutboxRepository(db.Collection("outbox"))
+	if err != nil {
+		panic(err)
+	}
+	eventOutbox := outbox.NewOutbox(outboxRepository)
+	relay := outbox.NewRelay(outboxRepository, producer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Start(ctx)
+	}()
+
+	productRepository := product.NewProductRepository(db)
+	productService := product.NewProductService(productRepository, eventOutbox)
 	productHandler := product.NewProductHandler(productService)
 
-	ms := microservice.NewMicroservice()
+	ms := microservice.NewMicroservice(cfg.HTTP, cfg.Log)
 	ms.GET("", func(c microservice.IContext) {
 		resp := map[string]any{
 			"name":    "go-http-service",
@@ -82,50 +85,76 @@ func main() {
 		c.JSON(200, resp)
 	})
 
-	ms.GET("healthz", healthCheck)
+	ms.GET("healthz", healthCheck(cfg.HTTP.Addr))
+	ms.GET("/.well-known/jwks.json", jwks)
 
 	ms.GET("/products", productHandler.FindAll)
 	ms.GET("/products/:id", productHandler.FindOne)
-	ms.POST("/products", productHandler.InsertProduct)
-	ms.PUT("/products", productHandler.InsertProduct)
-	ms.DELETE("/products/:id", productHandler.DeleteProduct)
-
-	productPriceRepository := price.NewProductPriceRepository(db.Collection("productPrice"))
-	productPriceService := price.NewProductPriceService(productPriceRepository, producer)
+	ms.GET("/products/:id/updates/:eventId", productHandler.FindUpdate)
+	ms.POST("/products", productHandler.InsertProduct, microservice.Scopes(auth.ScopeCatalogWrite))
+	ms.PUT("/products/:id", productHandler.UpdateProduct, microservice.Scopes(auth.ScopeCatalogWrite))
+	ms.PATCH("/products/:id", productHandler.UpdateProduct, microservice.Scopes(auth.ScopeCatalogWrite))
+	ms.DELETE("/products/:id", productHandler.DeleteProduct, microservice.Scopes(auth.ScopeCatalogWrite))
+
+	productPriceRepository := price.NewProductPriceRepository(db.Collection("productPrice"), db.Collection("productPriceHistory"))
+	productPriceService := price.NewProductPriceService(productPriceRepository, eventOutbox)
 	productPriceHandler := price.NewProductPriceHandler(productPriceService)
 
 	ms.GET("/productPrice", productPriceHandler.FindAll)
 	ms.GET("/productPrice/:id", productPriceHandler.FindOne)
-	ms.DELETE("/productPrice/:id", productPriceHandler.DeleteProductPrice)
-	ms.POST("/productPrice", productPriceHandler.InsertProductPrice)
+	ms.DELETE("/productPrice/:id", productPriceHandler.DeleteProductPrice, microservice.Scopes(auth.ScopePriceWrite))
+	ms.POST("/productPrice", productPriceHandler.InsertProductPrice, microservice.Scopes(auth.ScopePriceWrite))
+	ms.PATCH("/productPrice/:id", productPriceHandler.UpdateProductPrice, microservice.Scopes(auth.ScopePriceWrite))
 
-	categoryRepository := category.NewCategoryRepository(db.Collection("category"))
-	categoryService := category.NewCategoryService(categoryRepository, producer)
+	categoryRepository := category.NewCategoryRepository(db)
+	categoryService := category.NewCategoryService(categoryRepository, eventOutbox)
 	categoryHandler := category.NewCategoryHandler(categoryService)
 
-	ms.POST("/category", categoryHandler.InsertProduct)
+	ms.POST("/category", categoryHandler.InsertProduct, microservice.Scopes(auth.ScopeCatalogWrite))
 	ms.GET("/category", categoryHandler.FindCategories)
 	ms.GET("/category/:id", categoryHandler.FindOne)
-	ms.PATCH("/category/:id", categoryHandler.FindOne)
+	ms.PATCH("/category/:id", categoryHandler.UpdateCategory, microservice.Scopes(auth.ScopeCatalogWrite))
+	ms.DELETE("/category/:id", categoryHandler.DeleteCategory, microservice.Scopes(auth.ScopeCatalogWrite))
 
 	ms.Start()
+
+	cancel()
+	<-relayDone
 }
 
-func healthCheck(c microservice.IContext) {
-	type T struct {
-		Name    string `json:"name"`
-		Version string `json:"version"`
-		Status  string `json:"start"`
-	}
-	url, err := url.Parse("http://localhost:8080/")
+// jwks publishes the keys the consumers verify event tokens with.
+func jwks(c microservice.IContext) {
+	set, err := auth.JWKS()
 	if err != nil {
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
-	result, err := utils.HttpGetClient[T](url.String())
-	if err != nil {
-		c.Error(500, "Internal Server Error", err)
-		return
+	c.SetHeader("Cache-Control", "max-age=300")
+	c.JSON(200, set)
+}
+
+// healthCheck calls the root route of the server listening on addr.
+func healthCheck(addr string) microservice.ServiceHandleFunc {
+	return func(c microservice.IContext) {
+		type T struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Status  string `json:"start"`
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			c.Error(500, "Internal Server Error", err)
+			return
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		url := url.URL{Scheme: "http", Host: net.JoinHostPort(host, port), Path: "/"}
+		result, err := utils.HttpGetClient[T](url.String())
+		if err != nil {
+			c.Error(500, "Internal Server Error", err)
+			return
+		}
+		c.JSON(200, result)
 	}
-	c.JSON(200, result)
 }
@@ -1,11 +1,12 @@
 package microservice
 
 import (
-	"strconv"
-	"time"
+	"net/url"
+	"strings"
 
-	"github.com/IBM/sarama"
 	"github.com/gin-gonic/gin"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-product-service/middleware"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,8 +19,12 @@ type IContext interface {
 	ReadBodyJSON(obj any) error
 	Error(code int, msg string, err error)
 
-	GetHeader() ([]sarama.RecordHeader, map[string]any)
-	SetAuthorization(value string)
+	GetHeader() map[string]string
+	RequestHeader(key string) string
+	Subject() string
+	Scopes() []string
+	SetHeader(key, value string)
+	URL() *url.URL
 }
 
 type HTTPContext struct {
@@ -78,8 +83,15 @@ func (ctx *HTTPContext) LogInfo(name string, obj logrus.Fields) {
 	ctx.logger.WithFields(obj).Info(name)
 }
 
-func (ctx *HTTPContext) SetAuthorization(value string) {
-	ctx.Context.Request.Header.Set("Authorization", "Bearer "+value)
+// Subject returns the verified subject of the caller, see
+// middleware.Authorization.
+func (ctx *HTTPContext) Subject() string {
+	return ctx.Context.GetString(middleware.SubjectKey)
+}
+
+// Scopes returns the scopes granted to the caller.
+func (ctx *HTTPContext) Scopes() []string {
+	return ctx.Context.GetStringSlice(middleware.ScopesKey)
 }
 
 func (c *HTTPContext) Error(code int, msg string, err error) {
@@ -97,6 +109,18 @@ func (c *HTTPContext) Error(code int, msg string, err error) {
 	})
 }
 
+func (c *HTTPContext) RequestHeader(key string) string {
+	return c.Context.GetHeader(key)
+}
+
+func (c *HTTPContext) SetHeader(key, value string) {
+	c.Context.Header(key, value)
+}
+
+func (c *HTTPContext) URL() *url.URL {
+	return c.Context.Request.URL
+}
+
 func (c *HTTPContext) QueryString(name string) string {
 	return c.Context.Query(name)
 }
@@ -104,46 +128,19 @@ func (c *HTTPContext) Param(key string) string {
 	return c.Context.Param(key)
 }
 
-func (c *HTTPContext) GetHeader() ([]sarama.RecordHeader, map[string]any) {
-	statusCode := strconv.Itoa(c.Writer.Status())
-	authorization := c.Request.Header.Get("Authorization")
-	headers := []sarama.RecordHeader{
-		{Key: []byte("method"), Value: []byte(c.Request.Method)},
-		{Key: []byte("status"), Value: []byte(statusCode)},
-		{Key: []byte("client_ip"), Value: []byte(c.ClientIP())},
-		{Key: []byte("request_id"), Value: []byte(c.Writer.Header().Get("X-Request-Id"))},
-		{Key: []byte("remote_ip"), Value: []byte(c.Request.RemoteAddr)},
-		{Key: []byte("user_id"), Value: []byte(c.Request.URL.User.Username())},
-		{Key: []byte("user_agent"), Value: []byte(c.Request.UserAgent())},
-		{Key: []byte("error"), Value: []byte(c.Errors.ByType(gin.ErrorTypePrivate).String())},
-		{Key: []byte("request"), Value: []byte(c.Request.PostForm.Encode())},
-		{Key: []byte("body_size"), Value: []byte(strconv.Itoa(c.Writer.Size()))},
-		{Key: []byte("host"), Value: []byte(c.Request.Host)},
-		{Key: []byte("protocol"), Value: []byte(c.Request.Proto)},
-		{Key: []byte("path"), Value: []byte(c.Request.RequestURI)},
-		{Key: []byte("timezone"), Value: []byte(time.Now().Location().String())},
-		{Key: []byte("response_size"), Value: []byte(strconv.Itoa(c.Writer.Size()))},
-		{Key: []byte("Authorization"), Value: []byte(authorization)},
+// GetHeader returns the request metadata events caused by the request carry
+// in their record headers: the request id and trace context, to correlate
+// them with the request, and the caller the events are signed for.
+func (c *HTTPContext) GetHeader() map[string]string {
+	header := map[string]string{
+		kafka.HeaderRequestID: c.Context.GetString(middleware.RequestIDKey),
+		kafka.HeaderSubject:   c.Subject(),
+		kafka.HeaderScope:     strings.Join(c.Scopes(), " "),
 	}
-
-	header := map[string]any{
-		"method":        c.Request.Method,
-		"status":        statusCode,
-		"client_ip":     c.ClientIP(),
-		"request_id":    c.Writer.Header().Get("X-Request-Id"),
-		"remote_ip":     c.Request.RemoteAddr,
-		"user_id":       c.Request.URL.User.Username(),
-		"user_agent":    c.Request.UserAgent(),
-		"error":         c.Errors.ByType(gin.ErrorTypePrivate).String(),
-		"request":       c.Request.PostForm.Encode(),
-		"body_size":     c.Writer.Size(),
-		"host":          c.Request.Host,
-		"protocol":      c.Request.Proto,
-		"path":          c.Request.RequestURI,
-		"timezone":      time.Now().Location().String(),
-		"response_size": c.Writer.Size(),
-		"Authorization": authorization,
+	for _, key := range []string{kafka.HeaderTraceparent, kafka.HeaderTracestate} {
+		if value := c.Request.Header.Get(key); value != "" {
+			header[key] = value
+		}
 	}
-
-	return headers, header
+	return header
 }
@@ -10,67 +10,105 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sing3demons/go-product-service/logger"
+	"github.com/sing3demons/go-platform/logging"
+	"github.com/sing3demons/go-product-service/middleware"
+	"github.com/sirupsen/logrus"
 )
 
 type IMicroservice interface {
 	Start()
 
 	// HTTP Services
-	GET(path string, h ServiceHandleFunc)
-	POST(path string, h ServiceHandleFunc)
-	PUT(path string, h ServiceHandleFunc)
-	PATCH(path string, h ServiceHandleFunc)
-	DELETE(path string, h ServiceHandleFunc)
+	GET(path string, h ServiceHandleFunc, opts ...RouteOption)
+	POST(path string, h ServiceHandleFunc, opts ...RouteOption)
+	PUT(path string, h ServiceHandleFunc, opts ...RouteOption)
+	PATCH(path string, h ServiceHandleFunc, opts ...RouteOption)
+	DELETE(path string, h ServiceHandleFunc, opts ...RouteOption)
+}
+
+// Config is the HTTP server of the service. HostURL is the public URL of
+// the server, links in responses start with it.
+type Config struct {
+	Addr    string `yaml:"addr" env:"HTTP_ADDR" flag:"addr" default:":8080" validate:"required"`
+	HostURL string `yaml:"hostUrl" env:"HOST_URL"`
+	Mode    string `yaml:"mode" env:"GIN_MODE" default:"debug" validate:"oneof=debug release test"`
 }
 
 type Microservice struct {
 	*gin.Engine
-	logger *logger.Logger
+	logger *logrus.Logger
+	addr   string
 }
 
 type ServiceHandleFunc func(c IContext)
 
-func NewMicroservice() IMicroservice {
-	_log := logger.NewLogger()
+type route struct {
+	authenticate bool
+	scopes       []string
+}
+
+type RouteOption func(r *route)
+
+// Scopes requires callers to have one of scopes, or 403 is returned.
+func Scopes(scopes ...string) RouteOption {
+	return func(r *route) {
+		r.authenticate = true
+		r.scopes = append(r.scopes, scopes...)
+	}
+}
+
+// NewMicroservice returns the HTTP server of the service. Routes registered
+// with POST, PUT, PATCH and DELETE require an authenticated caller, see
+// middleware.Authorization.
+func NewMicroservice(cfg Config, log logging.Config) IMicroservice {
+	logger := logging.New(log)
+	gin.SetMode(cfg.Mode)
 	r := gin.Default()
-	r.Use(logger.LoggingMiddleware())
-	return &Microservice{r, _log}
+	r.Use(middleware.RequestID(), middleware.Logging(logger))
+	return &Microservice{r, logger, cfg.Addr}
 }
 
-func (ms *Microservice) GET(path string, handler ServiceHandleFunc) {
-	ms.Engine.GET(path, func(ctx *gin.Context) {
-		handler(NewContext(ms, ctx))
-	})
+func (ms *Microservice) GET(path string, handler ServiceHandleFunc, opts ...RouteOption) {
+	ms.handle(http.MethodGet, path, handler, route{}, opts)
 }
 
-func (ms *Microservice) POST(path string, handler ServiceHandleFunc) {
-	ms.Engine.POST(path, func(ctx *gin.Context) {
-		handler(NewContext(ms, ctx))
-	})
+func (ms *Microservice) POST(path string, handler ServiceHandleFunc, opts ...RouteOption) {
+	ms.handle(http.MethodPost, path, handler, route{authenticate: true}, opts)
 }
 
-func (ms *Microservice) PUT(path string, h ServiceHandleFunc) {
-	ms.Engine.PUT(path, func(ctx *gin.Context) {
-		h(NewContext(ms, ctx))
-	})
+func (ms *Microservice) PUT(path string, h ServiceHandleFunc, opts ...RouteOption) {
+	ms.handle(http.MethodPut, path, h, route{authenticate: true}, opts)
 }
 
-func (ms *Microservice) PATCH(path string, h ServiceHandleFunc) {
-	ms.Engine.PATCH(path, func(ctx *gin.Context) {
-		h(NewContext(ms, ctx))
-	})
+func (ms *Microservice) PATCH(path string, h ServiceHandleFunc, opts ...RouteOption) {
+	ms.handle(http.MethodPatch, path, h, route{authenticate: true}, opts)
+}
+
+func (ms *Microservice) DELETE(path string, handler ServiceHandleFunc, opts ...RouteOption) {
+	ms.handle(http.MethodDelete, path, handler, route{authenticate: true}, opts)
 }
 
-func (ms *Microservice) DELETE(path string, handler ServiceHandleFunc) {
-	ms.Engine.DELETE(path, func(ctx *gin.Context) {
+func (ms *Microservice) handle(method, path string, handler ServiceHandleFunc, r route, opts []RouteOption) {
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var handlers []gin.HandlerFunc
+	if r.authenticate {
+		handlers = append(handlers, middleware.Authorization())
+	}
+	if len(r.scopes) > 0 {
+		handlers = append(handlers, middleware.RequireScopes(r.scopes...))
+	}
+	handlers = append(handlers, func(ctx *gin.Context) {
 		handler(NewContext(ms, ctx))
 	})
+	ms.Engine.Handle(method, path, handlers...)
 }
 
 func (ms *Microservice) Start() {
 	s := &http.Server{
-		Addr:           ":8080",
+		Addr:           ms.addr,
 		Handler:        ms,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sing3demons/go-product-service/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Logging writes an access log entry to logger for every request.
+func Logging(logger *logrus.Logger) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		startTime := time.Now()
+		ctx.Next()
+		latencyTime := time.Since(startTime)
+
+		userID := ctx.GetString(SubjectKey)
+
+		logger.WithFields(logrus.Fields{
+			"headers":       utils.GetHeaders(ctx),
+			"user_id":       userID,
+			"method":        ctx.Request.Method,
+			"status":        ctx.Writer.Status(),
+			"latency":       latencyTime,
+			"error":         ctx.Errors.ByType(gin.ErrorTypePrivate).String(),
+			"request":       ctx.Request.PostForm.Encode(),
+			"body_size":     ctx.Writer.Size(),
+			"host":          ctx.Request.Host,
+			"protocol":      ctx.Request.Proto,
+			"path":          ctx.Request.RequestURI,
+			"query":         ctx.Request.URL.RawQuery,
+			"response_size": ctx.Writer.Size(),
+			"ContentType":   ctx.ContentType(),
+			"ContentLength": ctx.Request.ContentLength,
+			"timezone":      time.Now().Location().String(),
+			"ISOTime":       startTime,
+			"UnixTime":      startTime.UnixNano(),
+		}).Info("HTTP::REQUEST")
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDKey is the context key of the id of the request.
+const RequestIDKey = "requestId"
+
+// RequestID stores the X-Request-Id of the request, or a new id when it has
+// none, under RequestIDKey and returns it in the X-Request-Id of the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Request.Header.Get("X-Request-Id")
+		if id == "" {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header("X-Request-Id", id)
+		c.Next()
+	}
+}
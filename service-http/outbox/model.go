@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sing3demons/go-platform/auth"
+	"github.com/sing3demons/go-platform/contracts"
+	"github.com/sing3demons/go-platform/kafka"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A record is pending until a relay claims it for sending, see
+// IOutboxRepository.Claim, and sent once all of its messages were published.
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+)
+
+type Message struct {
+	EventID string            `json:"eventId" bson:"eventId"`
+	Topic   string            `json:"topic" bson:"topic"`
+	Key     string            `json:"key" bson:"key"`
+	Headers map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	Value   []byte            `json:"value" bson:"value"`
+}
+
+// Record is a single write request. All of its messages are stored in one
+// document so they are persisted atomically and published in order.
+type Record struct {
+	MID         primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	ID          string             `json:"id" bson:"id"`
+	AggregateID string             `json:"aggregateId" bson:"aggregateId"`
+	Messages    []Message          `json:"messages" bson:"messages"`
+	Published   int                `json:"published" bson:"published"`
+	Status      string             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	LastError   string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	NextAttempt time.Time          `json:"nextAttempt" bson:"nextAttempt"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	SentAt      *time.Time         `json:"sentAt,omitempty" bson:"sentAt,omitempty"`
+	ClaimedBy   string             `json:"claimedBy,omitempty" bson:"claimedBy,omitempty"`
+	ClaimedAt   *time.Time         `json:"claimedAt,omitempty" bson:"claimedAt,omitempty"`
+}
+
+// NewMessage wraps body in an event envelope for topic. The body is validated
+// against the latest schema of the topic's event type and signed for the
+// caller named in header, see kafka.HeaderSubject. The envelope, the request
+// metadata in header and the signature become the record headers, the value
+// only holds the body. The message is keyed by aggregateID so the events of
+// one aggregate stay in order.
+func NewMessage(topic, aggregateID string, header map[string]string, body any) (Message, error) {
+	event, err := contracts.NewEnvelope(uuid.NewString(), topic, aggregateID, header, body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	signature, err := auth.SignEvent(auth.EventSignature{
+		EventID:     event.EventID,
+		EventType:   event.EventType,
+		AggregateID: aggregateID,
+		Subject:     header[kafka.HeaderSubject],
+		Scopes:      strings.Fields(header[kafka.HeaderScope]),
+	}, event.Body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	headers := event.Headers()
+	headers[kafka.HeaderSignature] = signature
+	return Message{
+		EventID: event.EventID,
+		Topic:   topic,
+		Key:     aggregateID,
+		Headers: headers,
+		Value:   event.Body,
+	}, nil
+}
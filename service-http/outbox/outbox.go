@@ -0,0 +1,38 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/sing3demons/go-product-service/utils"
+)
+
+type IOutbox interface {
+	Publish(aggregateID string, messages ...Message) error
+}
+
+type outbox struct {
+	r IOutboxRepository
+}
+
+func NewOutbox(r IOutboxRepository) IOutbox {
+	return &outbox{r}
+}
+
+func (o *outbox) Publish(aggregateID string, messages ...Message) error {
+	id, err := utils.RandomNanoID(11)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	record := Record{
+		ID:          id,
+		AggregateID: aggregateID,
+		Messages:    messages,
+		Status:      StatusPending,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+
+	return o.r.Insert(record)
+}
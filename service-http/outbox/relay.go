@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pollInterval = 500 * time.Millisecond
+	batchSize    = 100
+	baseBackoff  = time.Second
+	maxBackoff   = time.Minute
+	// claimLease is how long a record stays claimed by a relay that stopped
+	// making progress on it, see IOutboxRepository.Claim.
+	claimLease = time.Minute
+)
+
+// Relay publishes pending outbox records to Kafka. A record is only marked
+// sent after every message was acknowledged, so delivery is at-least-once.
+// Each record is claimed before it is published, so the relays of several
+// instances don't publish it twice; only a relay that stalls longer than
+// claimLease loses its claim to another one. Records of the same aggregate
+// are published strictly in creation order: once one of them fails, or is
+// still claimed by another relay, later ones wait until it has gone through.
+type Relay struct {
+	r        IOutboxRepository
+	producer sarama.SyncProducer
+	owner    string
+}
+
+func NewRelay(r IOutboxRepository, producer sarama.SyncProducer) *Relay {
+	return &Relay{r, producer, uuid.NewString()}
+}
+
+func (relay *Relay) Start(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	logrus.Info("outbox relay has been started...")
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Info("outbox relay has been stopped")
+			return
+		case <-ticker.C:
+			relay.publishPending()
+		}
+	}
+}
+
+func (relay *Relay) publishPending() {
+	var blocked []string
+	for i := 0; i < batchSize; i++ {
+		record, err := relay.r.Claim(relay.owner, claimLease, blocked)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("outbox: claim record")
+			return
+		}
+		if record == nil {
+			return
+		}
+
+		earlier, err := relay.r.HasEarlier(*record)
+		if err != nil || earlier {
+			blocked = append(blocked, record.AggregateID)
+			relay.release(*record, err)
+			continue
+		}
+		if err := relay.publish(*record); err != nil {
+			blocked = append(blocked, record.AggregateID)
+			relay.fail(*record, err)
+		}
+	}
+}
+
+func (relay *Relay) publish(record Record) error {
+	eventProducer := kafka.NewEventProducer(relay.producer)
+	for i := record.Published; i < len(record.Messages); i++ {
+		msg := record.Messages[i]
+		// records stored before messages were keyed
+		key := msg.Key
+		if key == "" {
+			key = record.AggregateID
+		}
+		headers := kafka.RecordHeaders(msg.Headers)
+		// records stored before metadata moved to headers carry the
+		// envelope in the value
+		if len(headers) == 0 {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(kafka.HeaderEventID), Value: []byte(msg.EventID)})
+		}
+		if err := eventProducer.Send(msg.Topic, key, msg.Value, headers...); err != nil {
+			return err
+		}
+		if err := relay.r.MarkPublished(record.MID, relay.owner, i+1); err != nil {
+			return err
+		}
+	}
+	return relay.r.MarkSent(record.MID, relay.owner)
+}
+
+// release gives back a record that has to wait for an older one of its
+// aggregate, or couldn't be checked because of err.
+func (relay *Relay) release(record Record, err error) {
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"id":    record.ID,
+			"error": err,
+		}).Error("outbox: find earlier records")
+	}
+	if err := relay.r.Release(record.MID, relay.owner); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"id":    record.ID,
+			"error": err,
+		}).Error("outbox: release record")
+	}
+}
+
+func (relay *Relay) fail(record Record, err error) {
+	attempts := record.Attempts + 1
+	backoff := baseBackoff << (attempts - 1)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":          record.ID,
+		"aggregateId": record.AggregateID,
+		"attempts":    attempts,
+		"backoff":     backoff.String(),
+		"error":       err,
+	}).Error("outbox: publish record")
+
+	if err := relay.r.MarkFailed(record.MID, relay.owner, attempts, time.Now().UTC().Add(backoff), err.Error()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"id":    record.ID,
+			"error": err,
+		}).Error("outbox: mark record failed")
+	}
+}
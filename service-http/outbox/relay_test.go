@@ -0,0 +1,199 @@
+package outbox
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryRepository keeps the records in memory, in the order they were
+// created.
+type memoryRepository struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func (r *memoryRepository) Insert(record Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record.MID = primitive.NewObjectID()
+	r.records = append(r.records, &record)
+	return nil
+}
+
+func (r *memoryRepository) Claim(owner string, lease time.Duration, skip []string) (*Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for _, record := range r.records {
+		if slices.Contains(skip, record.AggregateID) {
+			continue
+		}
+		due := record.Status == StatusPending && !record.NextAttempt.After(now)
+		expired := record.Status == StatusSending && record.ClaimedAt.Before(now.Add(-lease))
+		if due || expired {
+			record.Status, record.ClaimedBy, record.ClaimedAt = StatusSending, owner, &now
+			claimed := *record
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryRepository) HasEarlier(record Record) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.records {
+		if other.MID == record.MID {
+			return false, nil
+		}
+		if other.AggregateID == record.AggregateID && other.Status != StatusSent {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRepository) Release(id primitive.ObjectID, owner string) error {
+	return r.update(id, owner, func(record *Record) {
+		record.Status, record.ClaimedBy, record.ClaimedAt = StatusPending, "", nil
+	})
+}
+
+func (r *memoryRepository) MarkPublished(id primitive.ObjectID, owner string, published int) error {
+	return r.update(id, owner, func(record *Record) {
+		now := time.Now().UTC()
+		record.Published, record.ClaimedAt = published, &now
+	})
+}
+
+func (r *memoryRepository) MarkSent(id primitive.ObjectID, owner string) error {
+	return r.update(id, owner, func(record *Record) {
+		now := time.Now().UTC()
+		record.Status, record.SentAt, record.ClaimedBy, record.ClaimedAt = StatusSent, &now, "", nil
+	})
+}
+
+func (r *memoryRepository) MarkFailed(id primitive.ObjectID, owner string, attempts int, nextAttempt time.Time, reason string) error {
+	return r.update(id, owner, func(record *Record) {
+		record.Status, record.ClaimedBy, record.ClaimedAt = StatusPending, "", nil
+		record.Attempts, record.NextAttempt, record.LastError = attempts, nextAttempt, reason
+	})
+}
+
+func (r *memoryRepository) update(id primitive.ObjectID, owner string, apply func(*Record)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range r.records {
+		if record.MID == id {
+			if record.ClaimedBy != owner {
+				return ErrClaimLost
+			}
+			apply(record)
+			return nil
+		}
+	}
+	return ErrClaimLost
+}
+
+// recordingProducer keeps the values it sent. Messages of the topic fail
+// are rejected, and onSend is called once, after the first message was sent.
+type recordingProducer struct {
+	sarama.SyncProducer
+	sent   []string
+	fail   string
+	onSend func()
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if msg.Topic == p.fail {
+		return 0, 0, errors.New("broker unavailable")
+	}
+	value, _ := msg.Value.Encode()
+	p.sent = append(p.sent, string(value))
+	if onSend := p.onSend; onSend != nil {
+		p.onSend = nil
+		onSend()
+	}
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+func publish(t *testing.T, r IOutboxRepository, aggregateID, topic string, values ...string) {
+	t.Helper()
+	var messages []Message
+	for _, value := range values {
+		messages = append(messages, Message{EventID: value, Topic: topic, Key: aggregateID, Value: []byte(value)})
+	}
+	if err := NewOutbox(r).Publish(aggregateID, messages...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelaysPublishEachRecordOnceInOrder(t *testing.T) {
+	repo := &memoryRepository{}
+	publish(t, repo, "a", "product.updated", "a1.1", "a1.2")
+	publish(t, repo, "b", "product.updated", "b1")
+	publish(t, repo, "a", "product.updated", "a2")
+
+	producer := &recordingProducer{}
+	first, second := NewRelay(repo, producer), NewRelay(repo, producer)
+	// the second relay polls while the first one sends a1
+	producer.onSend = second.publishPending
+	first.publishPending()
+
+	want := []string{"a1.1", "b1", "a1.2", "a2"}
+	if !slices.Equal(producer.sent, want) {
+		t.Errorf("sent %v, want %v", producer.sent, want)
+	}
+	for _, record := range repo.records {
+		if record.Status != StatusSent || record.ClaimedBy != "" {
+			t.Errorf("record of %s is %s, claimed by %q, want it sent", record.AggregateID, record.Status, record.ClaimedBy)
+		}
+	}
+}
+
+func TestRelayHoldsBackTheAggregateOfAFailedRecord(t *testing.T) {
+	repo := &memoryRepository{}
+	publish(t, repo, "a", "product.created", "a1")
+	publish(t, repo, "b", "product.updated", "b1")
+	publish(t, repo, "a", "product.updated", "a2")
+
+	producer := &recordingProducer{fail: "product.created"}
+	NewRelay(repo, producer).publishPending()
+
+	if want := []string{"b1"}; !slices.Equal(producer.sent, want) {
+		t.Errorf("sent %v, want %v", producer.sent, want)
+	}
+	failed := repo.records[0]
+	if failed.Status != StatusPending || failed.Attempts != 1 || !failed.NextAttempt.After(time.Now()) || failed.ClaimedBy != "" {
+		t.Errorf("failed record %+v, want it pending with a backoff and unclaimed", failed)
+	}
+	if repo.records[2].Status != StatusPending {
+		t.Errorf("later record of the failed aggregate is %s, want it pending", repo.records[2].Status)
+	}
+}
+
+func TestRelayTakesOverAnExpiredClaim(t *testing.T) {
+	repo := &memoryRepository{}
+	publish(t, repo, "a", "product.updated", "a1", "a2")
+	publish(t, repo, "b", "product.updated", "b1")
+	stalled := time.Now().UTC().Add(-2 * claimLease)
+	current := time.Now().UTC()
+	repo.records[0].Status, repo.records[0].ClaimedBy, repo.records[0].ClaimedAt, repo.records[0].Published = StatusSending, "stalled", &stalled, 1
+	repo.records[1].Status, repo.records[1].ClaimedBy, repo.records[1].ClaimedAt = StatusSending, "busy", &current
+
+	producer := &recordingProducer{}
+	NewRelay(repo, producer).publishPending()
+
+	if want := []string{"a2"}; !slices.Equal(producer.sent, want) {
+		t.Errorf("sent %v, want the rest of the stalled record only", producer.sent)
+	}
+	if err := repo.MarkSent(repo.records[0].MID, "stalled"); !errors.Is(err, ErrClaimLost) {
+		t.Errorf("MarkSent() by the stalled relay = %v, want %v", err, ErrClaimLost)
+	}
+}
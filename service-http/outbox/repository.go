@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrClaimLost means the claim of a relay on a record expired and another
+// relay claimed it since.
+var ErrClaimLost = errors.New("outbox: claim on the record lost")
+
+// IOutboxRepository stores the outbox records. Relays claim the records they
+// publish, so several instances never publish the same record at once; the
+// updates of a claimed record are made on behalf of the relay that owns it
+// and fail with ErrClaimLost once it doesn't.
+type IOutboxRepository interface {
+	Insert(record Record) error
+	// Claim claims the oldest record that is due, or whose claim expired
+	// after lease, for owner, skipping the records of the aggregates in skip.
+	// It returns nil when there is none.
+	Claim(owner string, lease time.Duration, skip []string) (*Record, error)
+	// HasEarlier reports whether an older record of the aggregate of record
+	// isn't sent yet.
+	HasEarlier(record Record) (bool, error)
+	// Release gives a claimed record back without counting an attempt.
+	Release(id primitive.ObjectID, owner string) error
+	// MarkPublished records the progress of owner and extends its claim.
+	MarkPublished(id primitive.ObjectID, owner string, published int) error
+	MarkSent(id primitive.ObjectID, owner string) error
+	MarkFailed(id primitive.ObjectID, owner string, attempts int, nextAttempt time.Time, reason string) error
+}
+
+type outboxRepository struct {
+	collection *mongo.Collection
+}
+
+// NewOutboxRepository creates the indexes the relay queries by, and fails
+// when they can't be created.
+func NewOutboxRepository(collection *mongo.Collection) (IOutboxRepository, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "aggregateId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "sentAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("outbox: create indexes: %w", err)
+	}
+
+	return &outboxRepository{collection}, nil
+}
+
+func (r *outboxRepository) Insert(record Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, record)
+	return err
+}
+
+func (r *outboxRepository) Claim(owner string, lease time.Duration, skip []string) (*Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.M{
+		"aggregateId": bson.M{"$nin": append([]string{}, skip...)},
+		"$or": []bson.M{
+			{"status": StatusPending, "nextAttempt": bson.M{"$lte": now}},
+			{"status": StatusSending, "claimedAt": bson.M{"$lt": now.Add(-lease)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"status":    StatusSending,
+		"claimedBy": owner,
+		"claimedAt": now,
+	}}
+	findOptions := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var record Record
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *outboxRepository) HasEarlier(record Record) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n, err := r.collection.CountDocuments(ctx, bson.M{
+		"aggregateId": record.AggregateID,
+		"status":      bson.M{"$ne": StatusSent},
+		"$or": []bson.M{
+			{"createdAt": bson.M{"$lt": record.CreatedAt}},
+			{"createdAt": record.CreatedAt, "_id": bson.M{"$lt": record.MID}},
+		},
+	}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (r *outboxRepository) Release(id primitive.ObjectID, owner string) error {
+	return r.update(id, owner, bson.M{"$set": bson.M{"status": StatusPending}, "$unset": unclaim})
+}
+
+func (r *outboxRepository) MarkPublished(id primitive.ObjectID, owner string, published int) error {
+	return r.update(id, owner, bson.M{"$set": bson.M{
+		"published": published,
+		"claimedAt": time.Now().UTC(),
+	}})
+}
+
+func (r *outboxRepository) MarkSent(id primitive.ObjectID, owner string) error {
+	return r.update(id, owner, bson.M{
+		"$set": bson.M{
+			"status":    StatusSent,
+			"sentAt":    time.Now().UTC(),
+			"lastError": "",
+		},
+		"$unset": unclaim,
+	})
+}
+
+func (r *outboxRepository) MarkFailed(id primitive.ObjectID, owner string, attempts int, nextAttempt time.Time, reason string) error {
+	return r.update(id, owner, bson.M{
+		"$set": bson.M{
+			"status":      StatusPending,
+			"attempts":    attempts,
+			"nextAttempt": nextAttempt,
+			"lastError":   reason,
+		},
+		"$unset": unclaim,
+	})
+}
+
+var unclaim = bson.M{"claimedBy": "", "claimedAt": ""}
+
+// update applies update to the record while owner holds the claim on it.
+func (r *outboxRepository) update(id primitive.ObjectID, owner string, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id, "claimedBy": owner}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
@@ -1,11 +1,18 @@
 package price
 
-import "github.com/sing3demons/go-product-service/microservice"
+import (
+	"errors"
+
+	"github.com/sing3demons/go-product-service/microservice"
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/utils"
+)
 
 type IProductPriceHandler interface {
 	FindAll(c microservice.IContext)
 	FindOne(c microservice.IContext)
 	InsertProductPrice(c microservice.IContext)
+	UpdateProductPrice(c microservice.IContext)
 	DeleteProductPrice(c microservice.IContext)
 }
 
@@ -25,6 +32,35 @@ func (h *productPriceHandler) InsertProductPrice(c microservice.IContext) {
 	}
 	id, err := h.svc.CreateProductPrice(c, req)
 	if err != nil {
+		if errors.Is(err, ErrInvalidEffectivePeriod) || errors.Is(err, ErrInvalidStatus) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
+		c.Error(500, "Internal Server Error", err)
+		return
+	}
+	c.JSON(200, map[string]string{
+		"message": "success",
+		"id":      id,
+	})
+}
+
+func (h *productPriceHandler) UpdateProductPrice(c microservice.IContext) {
+	var req UpdateProductPrice
+	if err := c.Body(&req); err != nil {
+		c.Error(400, "Bad Request", err)
+		return
+	}
+	id, err := h.svc.UpdateProductPrice(c, req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidEffectivePeriod) || errors.Is(err, ErrInvalidStatus) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
+		if err.Error() == "mongo: no documents in result" {
+			c.Error(404, "Not Found", err)
+			return
+		}
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
@@ -37,6 +73,10 @@ func (h *productPriceHandler) InsertProductPrice(c microservice.IContext) {
 func (h *productPriceHandler) FindOne(c microservice.IContext) {
 	product, err := h.svc.FindOne(c)
 	if err != nil {
+		if errors.Is(err, utils.ErrInvalidTimestamp) || errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		if err.Error() == "mongo: no documents in result" {
 			c.Error(404, "Not Found", err)
 			return
@@ -51,6 +91,10 @@ func (h *productPriceHandler) FindOne(c microservice.IContext) {
 func (h *productPriceHandler) FindAll(c microservice.IContext) {
 	result, err := h.svc.FindAll(c)
 	if err != nil {
+		if errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
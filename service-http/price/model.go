@@ -2,17 +2,39 @@ package price
 
 import "time"
 
-type Event struct {
-	Header any `json:"header"`
-	Body   any `json:"body"`
+type CreateProductPrice struct {
+	ID            string     `json:"id,omitempty" bson:"id,omitempty"`
+	Name          string     `json:"name,omitempty" bson:"name,omitempty"`
+	Status        string     `json:"status,omitempty" bson:"status,omitempty"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
 }
 
-type CreateProductPrice struct {
-	ID         string    `json:"id,omitempty" bson:"id,omitempty"`
-	Name       string    `json:"name,omitempty" bson:"name,omitempty"`
-	Status     string    `json:"status,omitempty" bson:"status,omitempty"`
-	Price      Price     `json:"price,omitempty" bson:"price,omitempty"`
-	LastUpdate time.Time `json:"lastUpdate" bson:"lastUpdate"`
+// UpdateProductPrice only carries the fields that change; nil fields are
+// left untouched. A future EffectiveFrom schedules the change, an
+// EffectiveTo limits it (e.g. a promotion) after which the previous price
+// applies again.
+type UpdateProductPrice struct {
+	ID            string     `json:"id,omitempty" bson:"id,omitempty"`
+	Name          *string    `json:"name,omitempty" bson:"name,omitempty"`
+	Status        *string    `json:"status,omitempty" bson:"status,omitempty"`
+	Price         *Price     `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
+}
+
+// PriceWindow is one entry of the price history maintained by the product
+// consumer. A nil EffectiveTo means the window is open-ended.
+type PriceWindow struct {
+	PriceID       string     `json:"priceId" bson:"priceId"`
+	Name          string     `json:"name" bson:"name"`
+	Status        string     `json:"status" bson:"status"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom time.Time  `json:"effectiveFrom" bson:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
 }
 
 type Price struct {
@@ -21,15 +43,17 @@ type Price struct {
 }
 
 type ProductPrice struct {
-	ID         string    `json:"id" bson:"id"`
-	Type       string    `json:"@type" bson:"@type"`
-	Status     string    `json:"status" bson:"status"`
-	Href       string    `json:"href"`
-	Name       string    `json:"name" bson:"name"`
-	Price      Price     `json:"price,omitempty" bson:"price,omitempty"`
-	LastUpdate time.Time `json:"lastUpdate" bson:"lastUpdate"`
+	ID            string     `json:"id" bson:"id"`
+	Type          string     `json:"@type" bson:"@type"`
+	Status        string     `json:"status" bson:"status"`
+	Href          string     `json:"href"`
+	Name          string     `json:"name" bson:"name"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
 }
 type DeleteProductPriceRequest struct {
 	ID         string    `json:"id" bson:"id"`
-	DeleteDate time.Time `json:"delete_date" bson:"deleteDate"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
 }
\ No newline at end of file
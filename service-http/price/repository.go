@@ -1,6 +1,9 @@
 package price
 
 import (
+	"context"
+	"time"
+
 	"github.com/sing3demons/go-product-service/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -8,34 +11,38 @@ import (
 )
 
 type IProductPriceRepository interface {
-	FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]ProductPrice, int64, error)
+	FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M) ([]ProductPrice, int64, error)
 	FindOne(filter bson.M, findOptions *options.FindOneOptions) (*ProductPrice, error)
+	FindAt(filter bson.M, at time.Time) (*ProductPrice, error)
 }
 
 type productPriceRepository struct {
 	collection *mongo.Collection
+	history    *mongo.Collection
 }
 
-func NewProductPriceRepository(collection *mongo.Collection) IProductPriceRepository {
-	return &productPriceRepository{collection}
+func NewProductPriceRepository(collection *mongo.Collection, history *mongo.Collection) IProductPriceRepository {
+	return &productPriceRepository{collection, history}
 }
 
-func (r *productPriceRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]ProductPrice, int64, error) {
+func (r *productPriceRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M) ([]ProductPrice, int64, error) {
 	productPrices := []ProductPrice{}
-	result, total, err := utils.GetMultiWithTotal[ProductPrice](r.collection, filter, findOptions)
+	result, total, err := utils.GetMultiWithTotal[ProductPrice](r.collection, filter, findOptions, after)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	for _, p := range result {
 		productPrices = append(productPrices, ProductPrice{
-			ID:         p.ID,
-			Type:       p.Type,
-			Status:     p.Status,
-			Href:       utils.Href(p.Type, p.ID),
-			Price:      p.Price,
-			Name:       p.Name,
-			LastUpdate: p.LastUpdate,
+			ID:            p.ID,
+			Type:          p.Type,
+			Status:        p.Status,
+			Href:          utils.Href(p.Type, p.ID),
+			Price:         p.Price,
+			Name:          p.Name,
+			LastUpdate:    p.LastUpdate,
+			EffectiveFrom: p.EffectiveFrom,
+			EffectiveTo:   p.EffectiveTo,
 		})
 	}
 	return productPrices, total, nil
@@ -49,13 +56,65 @@ func (r *productPriceRepository) FindOne(filter bson.M, findOptions *options.Fin
 	}
 
 	productPrice := ProductPrice{
-		ID:         p.ID,
-		Type:       p.Type,
-		Status:     p.Status,
-		Href:       utils.Href(p.Type, p.ID),
-		Name:       p.Name,
-		Price:      p.Price,
-		LastUpdate: p.LastUpdate,
+		ID:            p.ID,
+		Type:          p.Type,
+		Status:        p.Status,
+		Href:          utils.Href(p.Type, p.ID),
+		Name:          p.Name,
+		Price:         p.Price,
+		LastUpdate:    p.LastUpdate,
+		EffectiveFrom: p.EffectiveFrom,
+		EffectiveTo:   p.EffectiveTo,
 	}
 	return &productPrice, nil
 }
+
+// FindAt returns the price matching filter with the values that were valid at
+// the given time, mongo.ErrNoDocuments when none was. Prices without any
+// history keep their stored values from their last update on.
+func (r *productPriceRepository) FindAt(filter bson.M, at time.Time) (*ProductPrice, error) {
+	productPrice, err := r.FindOne(filter, &options.FindOneOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	historyFilter := bson.M{
+		"priceId":       productPrice.ID,
+		"effectiveFrom": bson.M{"$lte": at},
+		"$or": []bson.M{
+			{"effectiveTo": nil},
+			{"effectiveTo": bson.M{"$gt": at}},
+		},
+	}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "effectiveFrom", Value: -1}})
+	window, err := utils.GetOne[PriceWindow](r.history, historyFilter, findOptions)
+	if err == mongo.ErrNoDocuments {
+		return r.withoutWindow(productPrice, at)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	productPrice.Name = window.Name
+	productPrice.Status = window.Status
+	productPrice.Price = window.Price
+	productPrice.EffectiveFrom = &window.EffectiveFrom
+	productPrice.EffectiveTo = window.EffectiveTo
+	return productPrice, nil
+}
+
+// withoutWindow returns the stored price when no window was in effect at the
+// given time, that is when the price has no history and was valid by then.
+func (r *productPriceRepository) withoutWindow(productPrice *ProductPrice, at time.Time) (*ProductPrice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	windows, err := r.history.CountDocuments(ctx, bson.M{"priceId": productPrice.ID}, options.Count().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	if windows > 0 || at.Before(productPrice.LastUpdate) {
+		return nil, mongo.ErrNoDocuments
+	}
+	return productPrice, nil
+}
@@ -1,46 +1,70 @@
 package price
 
 import (
+	"errors"
 	"fmt"
-	"math"
-	"strconv"
+	"regexp"
 	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/contracts"
 	"github.com/sing3demons/go-product-service/microservice"
-	"github.com/sing3demons/go-product-service/producer"
+	"github.com/sing3demons/go-product-service/outbox"
+	"github.com/sing3demons/go-product-service/query"
 	"github.com/sing3demons/go-product-service/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrInvalidEffectivePeriod = errors.New("effectiveTo must be after effectiveFrom")
+	ErrInvalidStatus          = errors.New("status must be active or inActive")
+)
+
+var productPriceQuery = query.Spec{
+	Fields: []string{"status", "name", "price", "effectiveFrom", "effectiveTo", "lastUpdate"},
+}
+
+var productPriceSort = query.SortSpec{
+	"name":          "name",
+	"status":        "status",
+	"lastUpdate":    "lastUpdate",
+	"effectiveFrom": "effectiveFrom",
+	"price":         "price.value",
+}
+
 type IProductPriceService interface {
 	FindAll(c microservice.IContext) (any, error)
-	FindOne(c microservice.IContext) (*ProductPrice, error)
+	FindOne(c microservice.IContext) (any, error)
 	CreateProductPrice(c microservice.IContext, req CreateProductPrice) (string, error)
-	// EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (string, error)
+	UpdateProductPrice(c microservice.IContext, req UpdateProductPrice) (string, error)
 	DeleteProductPrice(c microservice.IContext) (string, error)
 }
 type productPriceService struct {
-	r        IProductPriceRepository
-	producer sarama.SyncProducer
+	r      IProductPriceRepository
+	outbox outbox.IOutbox
 }
 
-func NewProductPriceService(r IProductPriceRepository, producer sarama.SyncProducer) IProductPriceService {
-	return &productPriceService{r, producer}
+func NewProductPriceService(r IProductPriceRepository, outbox outbox.IOutbox) IProductPriceService {
+	return &productPriceService{r, outbox}
 }
 
 func (svc *productPriceService) FindAll(c microservice.IContext) (any, error) {
+	sel, err := productPriceQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
+	if err != nil {
+		return nil, err
+	}
+
 	filter := bson.M{}
 	findOptions := options.Find()
 	if s := c.QueryString("s"); s != "" {
+		pattern := regexp.QuoteMeta(s)
 		filter = bson.M{
 			"$or": []bson.M{
 				{
 					"title": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
@@ -48,7 +72,7 @@ func (svc *productPriceService) FindAll(c microservice.IContext) (any, error) {
 				{
 					"description": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
@@ -57,56 +81,66 @@ func (svc *productPriceService) FindAll(c microservice.IContext) (any, error) {
 		}
 	}
 
-	if sort := c.QueryString("sort"); sort != "" {
-		if sort == "asc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: 1}})
-		} else if sort == "desc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: -1}})
-		}
+	sort, err := productPriceSort.Parse(c.QueryString("sort"))
+	if err != nil {
+		return nil, err
 	}
 
-	p := c.QueryString("page")
-	if p == "" {
-		p = "1"
+	page, err := query.ParsePage(c.QueryString("limit"), c.QueryString("cursor"), c.QueryString("page"), sort)
+	if err != nil {
+		return nil, err
 	}
+	page.Apply(findOptions)
+	sel.Require(page.Fields()...)
+	sel.Apply(findOptions)
 
-	page, _ := strconv.Atoi(p)
-	if page == 0 {
-		page = 1
+	products, total, err := svc.r.FindAndTotal(filter, findOptions, page.After())
+	if err != nil {
+		return nil, err
 	}
-	var perPage int64 = 9
-
-	findOptions.SetSkip((int64(page) - 1) * perPage)
-	findOptions.SetLimit(perPage)
 
-	products, total, err := svc.r.FindAndTotal(filter, findOptions)
+	products, next, err := query.Cut(page, products)
 	if err != nil {
 		return nil, err
 	}
 
-	response := map[string]any{
-		"data":      products,
-		"total":     total,
-		"page":      page,
-		"last_page": int64(math.Ceil(float64(total) / float64(perPage))),
+	data, err := sel.Trim(products)
+	if err != nil {
+		return nil, err
 	}
 
+	response, link := page.Envelope(data, total, next, c.URL())
+	c.SetHeader("Link", link)
+
 	return response, nil
 }
-func (svc *productPriceService) FindOne(c microservice.IContext) (*ProductPrice, error) {
+func (svc *productPriceService) FindOne(c microservice.IContext) (any, error) {
 	id := c.Param("id")
 	if id == "" {
 		return nil, fmt.Errorf("id is required")
 	}
+	sel, err := productPriceQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
+	if err != nil {
+		return nil, err
+	}
+	at := time.Now().UTC()
 	filter := bson.M{"id": id, "deleteDate": nil}
-	findOptions := options.FindOneOptions{}
-	product, err := svc.r.FindOne(filter, &findOptions)
+	if value := c.QueryString("at"); value != "" {
+		t, err := utils.ParseTimestamp(value)
+		if err != nil {
+			return nil, err
+		}
+		// A price deleted since is returned as it was at the time.
+		at = t
+		filter = bson.M{"id": id, "$or": []bson.M{{"deleteDate": nil}, {"deleteDate": bson.M{"$gt": at}}}}
+	}
 
+	product, err := svc.r.FindAt(filter, at)
 	if err != nil {
 		return nil, err
 
 	}
-	return product, nil
+	return sel.Trim(product)
 }
 func (svc *productPriceService) CreateProductPrice(c microservice.IContext, req CreateProductPrice) (string, error) {
 	id, err := utils.RandomNanoID(11)
@@ -116,34 +150,80 @@ func (svc *productPriceService) CreateProductPrice(c microservice.IContext, req
 
 	if req.Status != "" {
 		if req.Status != "active" && req.Status != "inActive" {
-			return "", fmt.Errorf("status is required")
+			return "", fmt.Errorf("%w, got %q", ErrInvalidStatus, req.Status)
 		}
 	}
 
+	if err := validateEffectivePeriod(req.EffectiveFrom, req.EffectiveTo); err != nil {
+		return "", err
+	}
+
 	document := CreateProductPrice{
-		ID:     id,
-		Name:   req.Name,
-		Status: req.Status,
-		Price:  req.Price,
+		ID:            id,
+		Name:          req.Name,
+		Status:        req.Status,
+		Price:         req.Price,
+		EffectiveFrom: req.EffectiveFrom,
+		EffectiveTo:   req.EffectiveTo,
 	}
 
 	if !req.LastUpdate.IsZero() {
 		document.LastUpdate = req.LastUpdate
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   document,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductPriceCreated, id, header, document)
+	if err != nil {
+		return "", err
+	}
+	if err := svc.outbox.Publish(id, msg); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+func (svc *productPriceService) UpdateProductPrice(c microservice.IContext, req UpdateProductPrice) (string, error) {
+	id := c.Param("id")
+	if id == "" {
+		return "", fmt.Errorf("id is required")
 	}
 
-	eventProducer := producer.NewEventProducer(svc.producer)
-	if err := eventProducer.Produce("productPrice.created", data); err != nil {
+	if req.Status != nil {
+		if *req.Status != "active" && *req.Status != "inActive" {
+			return "", fmt.Errorf("%w, got %q", ErrInvalidStatus, *req.Status)
+		}
+	}
+
+	if err := validateEffectivePeriod(req.EffectiveFrom, req.EffectiveTo); err != nil {
+		return "", err
+	}
+
+	if _, err := svc.r.FindOne(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{}); err != nil {
+		return "", err
+	}
+
+	document := UpdateProductPrice{
+		ID:            id,
+		Name:          req.Name,
+		Status:        req.Status,
+		Price:         req.Price,
+		EffectiveFrom: req.EffectiveFrom,
+		EffectiveTo:   req.EffectiveTo,
+		LastUpdate:    time.Now().UTC(),
+	}
+
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductPriceUpdated, id, header, document)
+	if err != nil {
+		return "", err
+	}
+	if err := svc.outbox.Publish(id, msg); err != nil {
 		return "", err
 	}
 
 	return id, nil
 }
+
 func (svc *productPriceService) DeleteProductPrice(c microservice.IContext) (string, error) {
 	id := c.Param("id")
 	if id == "" {
@@ -155,16 +235,28 @@ func (svc *productPriceService) DeleteProductPrice(c microservice.IContext) (str
 		DeleteDate: time.Now().UTC(),
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   body,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductPriceDeleted, id, header, body)
+	if err != nil {
+		return "", err
 	}
-
-	eventProducer := producer.NewEventProducer(svc.producer)
-	if err := eventProducer.Produce("productPrice.deleted", data); err != nil {
+	if err := svc.outbox.Publish(id, msg); err != nil {
 		return "", err
 	}
 
 	return id, nil
 }
+
+func validateEffectivePeriod(from *time.Time, to *time.Time) error {
+	if to == nil {
+		return nil
+	}
+	start := time.Now()
+	if from != nil {
+		start = *from
+	}
+	if !to.After(start) {
+		return ErrInvalidEffectivePeriod
+	}
+	return nil
+}
@@ -1,14 +1,21 @@
 package product
 
 import (
+	"errors"
+
 	"github.com/sing3demons/go-product-service/microservice"
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type IProductHandler interface {
 	FindAll(c microservice.IContext)
 	FindOne(c microservice.IContext)
 	InsertProduct(c microservice.IContext)
+	UpdateProduct(c microservice.IContext)
 	DeleteProduct(c microservice.IContext)
+	FindUpdate(c microservice.IContext)
 }
 
 type ProductHandler struct {
@@ -22,6 +29,10 @@ func NewProductHandler(svc IProductService) *ProductHandler {
 func (h *ProductHandler) FindAll(c microservice.IContext) {
 	products, err := h.svc.FindAll(c)
 	if err != nil {
+		if errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
@@ -46,9 +57,58 @@ func (h *ProductHandler) InsertProduct(c microservice.IContext) {
 	})
 }
 
+func (h *ProductHandler) UpdateProduct(c microservice.IContext) {
+	var req UpdateProductRequest
+	if err := c.Body(&req); err != nil {
+		c.Error(400, "Bad Request", err)
+		return
+	}
+	update, err := h.svc.EventUpdateProduct(c, req)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.Error(404, "Not Found", err)
+			return
+		}
+		if err == ErrVersionConflict {
+			c.Error(409, "Conflict", err)
+			return
+		}
+		if err == ErrVersionRequired {
+			c.Error(428, "Precondition Required", err)
+			return
+		}
+		if errors.Is(err, ErrInvalidVersion) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
+		c.Error(500, "Internal Server Error", err)
+		return
+	}
+	c.SetHeader("Location", update.Href)
+	c.JSON(202, update)
+}
+
+// FindUpdate serves the status of an accepted update, see Update.
+func (h *ProductHandler) FindUpdate(c microservice.IContext) {
+	update, err := h.svc.FindUpdate(c)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.Error(404, "Not Found", err)
+			return
+		}
+		c.Error(500, "Internal Server Error", err)
+		return
+	}
+	c.JSON(200, update)
+}
+
 func (h *ProductHandler) FindOne(c microservice.IContext) {
 	product, err := h.svc.FindOne(c)
 	if err != nil {
+		if errors.Is(err, utils.ErrInvalidTimestamp) || errors.Is(err, query.ErrInvalidQuery) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		if err.Error() == "mongo: no documents in result" {
 			c.Error(404, "Not Found", err)
 			return
@@ -63,6 +123,18 @@ func (h *ProductHandler) FindOne(c microservice.IContext) {
 func (h *ProductHandler) DeleteProduct(c microservice.IContext) {
 	id, err := h.svc.EventDeleteProduct(c)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.Error(404, "Not Found", err)
+			return
+		}
+		if err == ErrPreconditionFailed {
+			c.Error(412, "Precondition Failed", err)
+			return
+		}
+		if errors.Is(err, ErrInvalidVersion) {
+			c.Error(400, "Bad Request", err)
+			return
+		}
 		c.Error(500, "Internal Server Error", err)
 		return
 	}
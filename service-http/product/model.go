@@ -7,17 +7,21 @@ import (
 )
 
 type Product struct {
-	MID          primitive.ObjectID `json:"_id" bson:"_id"`
-	Type         string             `json:"@type" bson:"@type"`
-	Status       string             `json:"status" bson:"status"`
-	Href         string             `json:"href"`
-	ID           string             `json:"id" bson:"id"`
-	Title        string             `json:"title,omitempty" bson:"title,omitempty"`
-	Description  string             `json:"description,omitempty" bson:"description,omitempty"`
-	Image        string             `json:"image,omitempty" bson:"image,omitempty"`
-	ProductPrice []ProductPrice     `json:"productPrice,omitempty" bson:"productPrice,omitempty"`
-	LastUpdate   time.Time          `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
-	Category     []Category         `json:"category,omitempty" bson:"category,omitempty"`
+	MID           primitive.ObjectID `json:"_id" bson:"_id"`
+	Type          string             `json:"@type" bson:"@type"`
+	Status        string             `json:"status" bson:"status"`
+	Href          string             `json:"href"`
+	ID            string             `json:"id" bson:"id"`
+	Version       int64              `json:"version" bson:"version"`
+	Title         string             `json:"title,omitempty" bson:"title,omitempty"`
+	Description   string             `json:"description,omitempty" bson:"description,omitempty"`
+	Image         string             `json:"image,omitempty" bson:"image,omitempty"`
+	ProductPrice  []ProductPrice     `json:"productPrice,omitempty" bson:"productPrice,omitempty"`
+	CurrentPrice  *Price             `json:"currentPrice,omitempty" bson:"currentPrice,omitempty"`
+	CurrentPrices []Price            `json:"currentPrices,omitempty" bson:"currentPrices,omitempty"`
+	Score         float64            `json:"score,omitempty" bson:"score,omitempty"`
+	LastUpdate    time.Time          `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	Category      []Category         `json:"category,omitempty" bson:"category,omitempty"`
 }
 
 type Category struct {
@@ -44,41 +48,84 @@ type CreateUpdateProductPrice struct {
 	Name string `json:"name" bson:"name"`
 }
 
+// UpdateProductRequest only carries the fields that change; nil fields are
+// left untouched. Version is the version the change was based on, it may be
+// sent in If-Match instead.
 type UpdateProductRequest struct {
-	Status       string                     `json:"status" bson:"status"`
-	Title        string                     `json:"title,omitempty" bson:"title,omitempty" form:"title,omitempty"`
-	Description  string                     `json:"description,omitempty" bson:"description,omitempty" form:"description,omitempty"`
-	Image        string                     `json:"image,omitempty" bson:"image,omitempty" form:"image,omitempty"`
-	ProductPrice []CreateUpdateProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty" form:"productPrice,omitempty"`
-	LastUpdate   time.Time                  `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
-	Category     []Category                 `json:"category,omitempty" bson:"category,omitempty"`
+	ID           string                      `json:"id" bson:"id"`
+	Version      *int64                      `json:"version" bson:"version" form:"version"`
+	Status       *string                     `json:"status,omitempty" bson:"status,omitempty" form:"status,omitempty"`
+	Title        *string                     `json:"title,omitempty" bson:"title,omitempty" form:"title,omitempty"`
+	Description  *string                     `json:"description,omitempty" bson:"description,omitempty" form:"description,omitempty"`
+	Image        *string                     `json:"image,omitempty" bson:"image,omitempty" form:"image,omitempty"`
+	ProductPrice *[]CreateUpdateProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty" form:"productPrice,omitempty"`
+	LastUpdate   time.Time                   `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	Category     *[]Category                 `json:"category,omitempty" bson:"category,omitempty"`
 }
 
+// Update statuses, see Update.
+const (
+	UpdatePending  = "pending"
+	UpdateApplied  = "applied"
+	UpdateRejected = "rejected"
+)
+
+// Update is an accepted update of a product. It is applied asynchronously by
+// the product consumer, which rejects it when another update of the product
+// was applied first. Its status is served at Href while the event is
+// remembered, see kafka.ConsumerConfig.LedgerTTL.
+type Update struct {
+	ID      string `json:"id"`
+	EventID string `json:"eventId"`
+	Version int64  `json:"version"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Href    string `json:"href"`
+}
+
+// revision is what decides the status of an Update.
+type revision struct {
+	Version     int64      `bson:"version"`
+	LastEventID string     `bson:"lastEventId"`
+	DeleteDate  *time.Time `bson:"deleteDate"`
+}
+
+// DeleteProductRequest deletes the product once the updates up to Version,
+// the version it was deleted at, have been applied.
 type DeleteProductRequest struct {
 	ID         string    `json:"id" bson:"id"`
-	DeleteDate time.Time `json:"delete_date" bson:"deleteDate"`
+	Version    int64     `json:"version" bson:"version"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
 }
 
 type DeleteProductPriceRequest struct {
 	ID         string    `json:"id" bson:"id"`
-	DeleteDate time.Time `json:"delete_date" bson:"deleteDate"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
 }
 
-type Event struct {
-	Header any `json:"header"`
-	Body   any `json:"body"`
+// priceWindow is an entry of the productPriceHistory collection.
+type priceWindow struct {
+	PriceID       string     `bson:"priceId"`
+	Name          string     `bson:"name"`
+	Status        string     `bson:"status"`
+	Price         *Price     `bson:"price,omitempty"`
+	EffectiveFrom time.Time  `bson:"effectiveFrom"`
+	EffectiveTo   *time.Time `bson:"effectiveTo,omitempty"`
 }
+
 type Price struct {
 	Unit  string  `json:"unit,omitempty" bson:"unit,omitempty"`
 	Value float64 `json:"value,omitempty" bson:"value,omitempty"`
 }
 
 type ProductPrice struct {
-	ID         string    `json:"id,omitempty" bson:"id,omitempty"`
-	Type       string    `json:"@type,omitempty" bson:"@type,omitempty"`
-	Status     string    `json:"status,omitempty" bson:"status,omitempty"`
-	Href       string    `json:"href,omitempty"`
-	Name       string    `json:"name,omitempty" bson:"name,omitempty"`
-	Price      *Price     `json:"price,omitempty" bson:"price,omitempty"`
-	LastUpdate *time.Time `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	ID            string     `json:"id,omitempty" bson:"id,omitempty"`
+	Type          string     `json:"@type,omitempty" bson:"@type,omitempty"`
+	Status        string     `json:"status,omitempty" bson:"status,omitempty"`
+	Href          string     `json:"href,omitempty"`
+	Name          string     `json:"name,omitempty" bson:"name,omitempty"`
+	Price         *Price     `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    *time.Time `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
 }
@@ -2,8 +2,13 @@ package product
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
+	"github.com/sing3demons/go-platform/contracts"
+	"github.com/sing3demons/go-product-service/outbox"
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/resolver"
 	"github.com/sing3demons/go-product-service/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,152 +16,89 @@ import (
 )
 
 type IProductRepository interface {
-	FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]Product, int64, error)
+	FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M, sel *query.Selection) ([]Product, int64, error)
 	InsertOne(document interface{}) (interface{}, error)
-	FindProduct(filter bson.M, findOptions *options.FindOneOptions) (*Product, error)
+	FindProduct(filter bson.M, findOptions *options.FindOneOptions, sel *query.Selection) (*Product, error)
+	FindProductAt(filter bson.M, at time.Time, sel *query.Selection) (*Product, error)
+	FindRevision(id string) (*revision, error)
+	FindUpdateVersion(id, eventID string) (int64, error)
+	IsProcessed(eventID string) (bool, error)
 }
 
 type productRepository struct {
 	collection *mongo.Collection
+	view       *mongo.Collection
+	prices     *mongo.Collection
+	history    *mongo.Collection
+	categories *mongo.Collection
+	outbox     *mongo.Collection
+	processed  *mongo.Collection
 }
 
-func NewProductRepository(collection *mongo.Collection) IProductRepository {
-	return &productRepository{collection}
+func NewProductRepository(db *mongo.Database) IProductRepository {
+	return &productRepository{
+		collection: db.Collection("product"),
+		view:       db.Collection("productView"),
+		prices:     db.Collection("productPrice"),
+		history:    db.Collection("productPriceHistory"),
+		categories: db.Collection("category"),
+		outbox:     db.Collection("outbox"),
+		processed:  db.Collection("productProcessedEvent"),
+	}
 }
 
-func (r *productRepository) FindAll(filter bson.M, findOptions *options.FindOptions) ([]Product, error) {
-	products := []Product{}
+func (r *productRepository) FindAll(filter bson.M, findOptions *options.FindOptions, sel *query.Selection) ([]Product, error) {
+	sel.Apply(findOptions)
 	result, err := utils.GetMulti[Product](r.collection, filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, p := range result {
-
-		var productPrice []ProductPrice
-		if len(p.ProductPrice) != 0 {
-			for _, v := range p.ProductPrice {
-				p, err := utils.HttpGetClient[ProductPrice]("http://localhost:8080/productPrice/" + v.ID)
-				if err != nil {
-					productPrice = append(productPrice, ProductPrice{
-						ID:   p.ID,
-						Type: p.Type,
-						Name: p.Name,
-					})
-				}
-				productPrice = append(productPrice, ProductPrice{
-					ID:         p.ID,
-					Type:       p.Type,
-					Href:       utils.Href(p.Type, p.ID),
-					Price:      p.Price,
-					Name:       p.Name,
-					Status:     p.Status,
-					LastUpdate: p.LastUpdate,
-				})
-			}
-		} else {
-			productPrice = p.ProductPrice
-		}
-		products = append(products, Product{
-			ID:           p.ID,
-			Type:         p.Type,
-			Href:         utils.Href(p.Type, p.ID),
-			Title:        p.Title,
-			ProductPrice: productPrice,
-			Description:  p.Description,
-			Image:        p.Image,
-			Status:       p.Status,
-			LastUpdate:   p.LastUpdate,
-		})
-	}
-
-	return products, nil
+	return r.enrich(result, nil, sel)
 }
 
-func (r *productRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions) ([]Product, int64, error) {
-	products := []Product{}
-	result, total, err := utils.GetMultiWithTotal[Product](r.collection, filter, findOptions)
+// FindAndTotal lists products from productView, which the product consumer
+// keeps with prices and categories embedded, so no further lookups are made.
+// Deleted products stay in the view and are left out here.
+func (r *productRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M, sel *query.Selection) ([]Product, int64, error) {
+	sel.Apply(findOptions)
+	filter = bson.M{"$and": []bson.M{filter, {"deleteDate": nil}}}
+	result, total, err := utils.GetMultiWithTotal[Product](r.view, filter, findOptions, after)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	prices := map[string]ProductPrice{}
+	categories := map[string]Category{}
 	for _, p := range result {
-		var categories []Category
-		if len(p.Category) != 0 {
-			for _, v := range p.Category {
-				c, err := utils.HttpGetClient[Category]("http://localhost:8080/category/" + v.ID)
-				if err != nil {
-					categories = append(categories, Category{
-						ID:   c.ID,
-						Type: c.Type,
-						Name: c.Name,
-					})
-				}
-				categories = append(categories, Category{
-					ID:   c.ID,
-					Type: c.Type,
-					Href: utils.Href(c.Type, c.ID),
-					Name: c.Name,
-				})
+		if sel.Expands("productPrice") {
+			for _, v := range p.ProductPrice {
+				prices[v.ID] = v
 			}
-		} else {
-			categories = p.Category
 		}
-		var productPrice []ProductPrice
-		if len(p.ProductPrice) != 0 {
-			for _, v := range p.ProductPrice {
-				price, err := utils.HttpGetClient[ProductPrice]("http://localhost:8080/productPrice/" + v.ID)
-				if err != nil {
-					productPrice = append(productPrice, ProductPrice{
-						ID:   price.ID,
-						Type: price.Type,
-					})
-					continue
-				}
-				if price.ID != "" {
-					productPrice = append(productPrice, ProductPrice{
-						ID:         price.ID,
-						Type:       price.Type,
-						Href:       utils.Href(price.Type, price.ID),
-						Status:     price.Status,
-						Price:      price.Price,
-						Name:       price.Name,
-						LastUpdate: price.LastUpdate,
-					})
-				} else {
-					r := ProductPrice{
-						ID:   v.ID,
-						Type: "productPrice",
-					}
-					productPrice = append(productPrice, r)
-
-				}
+		if sel.Expands("category") {
+			for _, v := range p.Category {
+				categories[v.ID] = v
 			}
-		} else {
-			productPrice = append(productPrice, ProductPrice{
-				ID:   p.ID,
-				Type: p.Type,
-			})
 		}
-		products = append(products, Product{
-			MID:          p.MID,
-			ID:           p.ID,
-			Type:         p.Type,
-			Category:     categories,
-			Status:       p.Status,
-			Href:         utils.Href(p.Type, p.ID),
-			Title:        p.Title,
-			ProductPrice: productPrice,
-			Description:  p.Description,
-			Image:        p.Image,
-			LastUpdate:   p.LastUpdate,
-		})
 	}
 
-	return products, total, nil
+	return assemble(result, prices, categories), total, nil
+}
+
+func (r *productRepository) FindProduct(filter bson.M, findOptions *options.FindOneOptions, sel *query.Selection) (*Product, error) {
+	return r.findProduct(filter, findOptions, nil, sel)
 }
 
-func (r *productRepository) FindProduct(filter bson.M, findOptions *options.FindOneOptions) (*Product, error) {
+// FindProductAt resolves the product prices valid at the given time.
+func (r *productRepository) FindProductAt(filter bson.M, at time.Time, sel *query.Selection) (*Product, error) {
+	return r.findProduct(filter, &options.FindOneOptions{}, &at, sel)
+}
+
+func (r *productRepository) findProduct(filter bson.M, findOptions *options.FindOneOptions, at *time.Time, sel *query.Selection) (*Product, error) {
+	if projection := sel.Projection(); projection != nil {
+		findOptions.SetProjection(projection)
+	}
 	p, err := utils.GetOne[Product](r.collection, filter, findOptions)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -165,79 +107,170 @@ func (r *productRepository) FindProduct(filter bson.M, findOptions *options.Find
 		return nil, err
 	}
 
-	var categories []Category
-	if len(p.Category) != 0 {
-		for _, v := range p.Category {
-			c, err := utils.HttpGetClient[Category]("http://localhost:8080/category/" + v.ID)
-			if err != nil {
-				categories = append(categories, Category{
-					ID:   c.ID,
-					Type: c.Type,
-				})
+	products, err := r.enrich([]Product{*p}, at, sel)
+	if err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+// enrich replaces the price and category references of products with the
+// referenced documents, resolving each kind with a single query. References
+// that aren't expanded or no longer resolve are kept as bare ids. With at set,
+// prices carry the values valid at that time.
+func (r *productRepository) enrich(result []Product, at *time.Time, sel *query.Selection) ([]Product, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var priceIDs, categoryIDs []string
+	for _, p := range result {
+		if sel.Expands("productPrice") {
+			for _, v := range p.ProductPrice {
+				priceIDs = append(priceIDs, v.ID)
+			}
+		}
+		if sel.Expands("category") {
+			for _, v := range p.Category {
+				categoryIDs = append(categoryIDs, v.ID)
 			}
-			categories = append(categories, Category{
-				ID:   c.ID,
-				Type: c.Type,
-				Href: utils.Href(c.Type, c.ID),
-				Name: c.Name,
-			})
 		}
-	} else {
-		categories = p.Category
 	}
 
-	var productPrice []ProductPrice
-	if len(p.ProductPrice) != 0 {
+	prices, err := resolver.NewLoader(r.fetchPrices(at), func(p ProductPrice) string { return p.ID }).LoadMany(ctx, priceIDs)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := resolver.NewLoader(resolver.FindByID[Category](r.categories), func(c Category) string { return c.ID }).LoadMany(ctx, categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return assemble(result, prices, categories), nil
+}
+
+// assemble replaces the references of products with the given prices and
+// categories. References missing from them are kept as bare ids.
+func assemble(result []Product, prices map[string]ProductPrice, categories map[string]Category) []Product {
+	products := []Product{}
+	for _, p := range result {
+		var productPrice []ProductPrice
 		for _, v := range p.ProductPrice {
-			price, err := utils.HttpGetClient[ProductPrice]("http://localhost:8080/productPrice/" + v.ID)
-			if err != nil {
+			price, ok := prices[v.ID]
+			if !ok {
 				productPrice = append(productPrice, ProductPrice{
-					ID:   price.ID,
-					Type: price.Type,
+					ID:   v.ID,
+					Type: "productPrice",
+					Href: utils.Href("productPrice", v.ID),
+					Name: v.Name,
 				})
 				continue
 			}
-			if price.ID != "" {
-				productPrice = append(productPrice, ProductPrice{
-					ID:         price.ID,
-					Type:       price.Type,
-					Href:       utils.Href(price.Type, price.ID),
-					Status:     price.Status,
-					Price:      price.Price,
-					Name:       price.Name,
-					LastUpdate: price.LastUpdate,
-				})
-			} else {
-				r := ProductPrice{
-					ID:   v.ID,
-					Type: "productPrice",
-				}
-				productPrice = append(productPrice, r)
+			productPrice = append(productPrice, ProductPrice{
+				ID:            price.ID,
+				Type:          price.Type,
+				Href:          utils.Href(price.Type, price.ID),
+				Status:        price.Status,
+				Price:         price.Price,
+				Name:          price.Name,
+				LastUpdate:    price.LastUpdate,
+				EffectiveFrom: price.EffectiveFrom,
+				EffectiveTo:   price.EffectiveTo,
+			})
+		}
 
+		var category []Category
+		for _, v := range p.Category {
+			c, ok := categories[v.ID]
+			if !ok {
+				category = append(category, Category{
+					ID:   v.ID,
+					Type: "category",
+					Href: utils.Href("category", v.ID),
+					Name: v.Name,
+				})
+				continue
 			}
+			category = append(category, Category{
+				ID:   c.ID,
+				Type: c.Type,
+				Href: utils.Href(c.Type, c.ID),
+				Name: c.Name,
+			})
 		}
-	} else {
-		productPrice = append(productPrice, ProductPrice{
-			ID:   p.ID,
-			Type: p.Type,
+
+		products = append(products, Product{
+			MID:           p.MID,
+			ID:            p.ID,
+			Version:       p.Version,
+			Type:          p.Type,
+			Status:        p.Status,
+			Category:      category,
+			Href:          utils.Href(p.Type, p.ID),
+			Title:         p.Title,
+			ProductPrice:  productPrice,
+			CurrentPrice:  p.CurrentPrice,
+			CurrentPrices: p.CurrentPrices,
+			Score:         p.Score,
+			Description:   p.Description,
+			Image:         p.Image,
+			LastUpdate:    p.LastUpdate,
 		})
 	}
 
-	product := &Product{
-		MID:          p.MID,
-		ID:           p.ID,
-		Type:         p.Type,
-		Status:       p.Status,
-		Category:     categories,
-		Href:         utils.Href(p.Type, p.ID),
-		Title:        p.Title,
-		ProductPrice: productPrice,
-		Description:  p.Description,
-		Image:        p.Image,
-		LastUpdate:   p.LastUpdate,
+	return products
+}
+
+// fetchPrices loads prices by id. With at set, the values of the history
+// window valid at that time replace the stored ones.
+func (r *productRepository) fetchPrices(at *time.Time) resolver.FetchFunc[ProductPrice] {
+	findPrices := resolver.FindByID[ProductPrice](r.prices)
+	if at == nil {
+		return findPrices
 	}
 
-	return product, nil
+	return func(ctx context.Context, ids []string) ([]ProductPrice, error) {
+		prices, err := findPrices(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		cur, err := r.history.Find(ctx, bson.M{
+			"priceId":       bson.M{"$in": ids},
+			"effectiveFrom": bson.M{"$lte": *at},
+			"$or": []bson.M{
+				{"effectiveTo": nil},
+				{"effectiveTo": bson.M{"$gt": *at}},
+			},
+		}, options.Find().SetSort(bson.D{{Key: "effectiveFrom", Value: -1}}))
+		if err != nil {
+			return nil, err
+		}
+
+		var windows []priceWindow
+		if err := cur.All(ctx, &windows); err != nil {
+			return nil, err
+		}
+
+		valid := map[string]priceWindow{}
+		for _, w := range windows {
+			if _, ok := valid[w.PriceID]; !ok {
+				valid[w.PriceID] = w
+			}
+		}
+
+		for i, p := range prices {
+			w, ok := valid[p.ID]
+			if !ok {
+				continue
+			}
+			prices[i].Name = w.Name
+			prices[i].Status = w.Status
+			prices[i].Price = w.Price
+			prices[i].EffectiveFrom = &w.EffectiveFrom
+			prices[i].EffectiveTo = w.EffectiveTo
+		}
+		return prices, nil
+	}
 }
 
 func (r *productRepository) InsertOne(document interface{}) (interface{}, error) {
@@ -251,3 +284,60 @@ func (r *productRepository) InsertOne(document interface{}) (interface{}, error)
 
 	return result.InsertedID, nil
 }
+
+// FindRevision returns the version of product id, deleted or not.
+func (r *productRepository) FindRevision(id string) (*revision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var result revision
+	err := r.collection.FindOne(ctx, bson.M{"id": id}, options.FindOne().SetProjection(bson.M{
+		"version":     1,
+		"lastEventId": 1,
+		"deleteDate":  1,
+	})).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FindUpdateVersion returns the version the update of product id published
+// as eventID was based on. mongo.ErrNoDocuments is returned once the outbox
+// record has expired.
+func (r *productRepository) FindUpdateVersion(id, eventID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var record outbox.Record
+	err := r.outbox.FindOne(ctx, bson.M{"aggregateId": id, "messages.eventId": eventID}).Decode(&record)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range record.Messages {
+		if msg.EventID != eventID || msg.Topic != contracts.ProductUpdated {
+			continue
+		}
+		var update UpdateProductRequest
+		if err := json.Unmarshal(msg.Value, &update); err != nil {
+			return 0, err
+		}
+		if update.Version != nil {
+			return *update.Version, nil
+		}
+	}
+	return 0, mongo.ErrNoDocuments
+}
+
+// IsProcessed tells whether the product consumer has handled eventID.
+func (r *productRepository) IsProcessed(eventID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := r.processed.CountDocuments(ctx, bson.M{"eventId": eventID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -1,14 +1,17 @@
 package product
 
 import (
+	"errors"
 	"fmt"
-	"math"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/contracts"
 	"github.com/sing3demons/go-product-service/microservice"
-	"github.com/sing3demons/go-product-service/producer"
+	"github.com/sing3demons/go-product-service/outbox"
+	"github.com/sing3demons/go-product-service/query"
 
 	"github.com/sing3demons/go-product-service/utils"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,32 +19,87 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrVersionConflict = errors.New("product has been modified, reload and retry")
+	ErrVersionRequired = errors.New("the version the update is based on is required, in If-Match or the body")
+	ErrInvalidVersion  = errors.New("invalid version")
+	// ErrPreconditionFailed means the If-Match of a delete isn't the current
+	// version of the product.
+	ErrPreconditionFailed = errors.New("product has been modified, If-Match doesn't match its version")
+)
+
+var productQuery = query.Spec{
+	Fields: []string{"status", "version", "title", "description", "image", "productPrice", "currentPrice", "currentPrices", "category", "lastUpdate"},
+	Expand: []string{"productPrice", "category"},
+}
+
+const (
+	searchText   = "text"
+	searchPrefix = "prefix"
+)
+
+var productSort = query.SortSpec{
+	"title":      "title",
+	"status":     "status",
+	"lastUpdate": "lastUpdate",
+	"price":      "currentPrice.unit,currentPrice.value",
+}
+
+var productFilter = query.FilterSpec{
+	{Name: "id", Ops: []string{"eq", "in"}},
+	{Name: "status", Ops: []string{"eq", "ne", "in", "nin"}},
+	{Name: "title", Ops: []string{"eq"}},
+	{Name: "category.id", Ops: []string{"eq", "ne", "in", "nin"}},
+	{Name: "productPrice.id", Ops: []string{"eq", "in"}},
+	{Name: "price.value", Kind: query.Number, Ops: []string{"eq", "gt", "gte", "lt", "lte"}, Ref: "productPrice"},
+	{Name: "price.unit", Ops: []string{"eq", "in"}, Ref: "productPrice"},
+	{Name: "lastUpdate", Kind: query.Time, Ops: []string{"gt", "gte", "lt", "lte"}},
+}
+
 type IProductService interface {
 	FindAll(c microservice.IContext) (any, error)
-	FindOne(c microservice.IContext) (*Product, error)
+	FindOne(c microservice.IContext) (any, error)
 	EventCreateProduct(c microservice.IContext, req CreateProductRequest) (string, error)
-	EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (string, error)
+	EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (*Update, error)
 	EventDeleteProduct(c microservice.IContext) (string, error)
+	FindUpdate(c microservice.IContext) (*Update, error)
 }
 type productService struct {
-	r        IProductRepository
-	producer sarama.SyncProducer
+	r      IProductRepository
+	outbox outbox.IOutbox
 }
 
-func NewProductService(r IProductRepository, producer sarama.SyncProducer) IProductService {
-	return &productService{r, producer}
+func NewProductService(r IProductRepository, outbox outbox.IOutbox) IProductService {
+	return &productService{r, outbox}
 }
 
 func (s *productService) FindAll(c microservice.IContext) (any, error) {
-	filter := bson.M{}
+	sel, err := productQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := productFilter.Parse(c.URL().Query())
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []bson.M{f.Match}
+	if match, ok := f.Refs["productPrice"]; ok {
+		// productView embeds the prices, so one of them has to match all
+		// conditions
+		conditions = append(conditions, bson.M{"productPrice": bson.M{"$elemMatch": match}})
+	}
+
 	findOptions := options.Find()
 	if s := c.QueryString("s"); s != "" {
-		filter = bson.M{
+		pattern := regexp.QuoteMeta(s)
+		conditions = append(conditions, bson.M{
 			"$or": []bson.M{
 				{
 					"title": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
@@ -49,48 +107,119 @@ func (s *productService) FindAll(c microservice.IContext) (any, error) {
 				{
 					"description": bson.M{
 						"$regex": primitive.Regex{
-							Pattern: s,
+							Pattern: pattern,
 							Options: "i",
 						},
 					},
 				},
 			},
-		}
+		})
+	}
+	if q := c.QueryString("q"); q != "" {
+		return s.search(c, sel, conditions, q)
 	}
 
-	if sort := c.QueryString("sort"); sort != "" {
-		if sort == "asc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: 1}})
-		} else if sort == "desc" {
-			findOptions.SetSort(bson.D{{Key: "price", Value: -1}})
-		}
+	filter := bson.M{"$and": conditions}
+
+	sort, err := productSort.Parse(c.QueryString("sort"))
+	if err != nil {
+		return nil, err
 	}
 
-	p := c.QueryString("page")
-	if p == "" {
-		p = "1"
+	page, err := query.ParsePage(c.QueryString("limit"), c.QueryString("cursor"), c.QueryString("page"), sort)
+	if err != nil {
+		return nil, err
 	}
+	page.Apply(findOptions)
+	sel.Require(page.Fields()...)
 
-	page, _ := strconv.Atoi(p)
-	if page == 0 {
-		page = 1
+	products, total, err := s.r.FindAndTotal(filter, findOptions, page.After(), sel)
+	if err != nil {
+		return nil, err
 	}
-	var perPage int64 = 9
 
-	findOptions.SetSkip((int64(page) - 1) * perPage)
-	findOptions.SetLimit(perPage)
+	products, next, err := query.Cut(page, products)
+	if err != nil {
+		return nil, err
+	}
 
-	products, total, err := s.r.FindAndTotal(filter, findOptions)
+	data, err := sel.Trim(products)
 	if err != nil {
 		return nil, err
 	}
 
-	response := map[string]any{
-		"data":      products,
-		"total":     total,
-		"page":      page,
-		"last_page": int64(math.Ceil(float64(total) / float64(perPage))),
+	response, link := page.Envelope(data, total, next, c.URL())
+	c.SetHeader("Link", link)
+
+	return response, nil
+}
+
+// search ranks the products matching the text index by relevance; phrases
+// ("...") and negations (-word) follow the Mongo $text syntax. mode=prefix, or
+// a text search without results, matches title prefixes instead for
+// typeahead. Search results are paged by number only.
+func (s *productService) search(c microservice.IContext, sel *query.Selection, conditions []bson.M, q string) (any, error) {
+	mode := c.QueryString("mode")
+	if mode != "" && mode != searchText && mode != searchPrefix {
+		return nil, fmt.Errorf("%w: mode must be %s or %s", query.ErrInvalidQuery, searchText, searchPrefix)
 	}
+	if c.QueryString("sort") != "" {
+		return nil, fmt.Errorf("%w: search results are sorted by relevance", query.ErrInvalidQuery)
+	}
+
+	page, err := query.ParseOffsetPage(c.QueryString("limit"), c.QueryString("cursor"), c.QueryString("page"))
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	var total int64
+	if mode != searchPrefix {
+		score := bson.M{"$meta": "textScore"}
+		findOptions := options.Find().SetProjection(bson.D{{Key: "score", Value: score}})
+		page.Apply(findOptions)
+		findOptions.SetSort(bson.D{{Key: "score", Value: score}, {Key: "id", Value: 1}})
+
+		filter := bson.M{"$and": []bson.M{{"$and": conditions}, {"$text": bson.M{"$search": q}}}}
+		products, total, err = s.r.FindAndTotal(filter, findOptions, nil, sel)
+		if err != nil {
+			return nil, err
+		}
+
+		if mode == "" {
+			mode = searchText
+			if total == 0 {
+				mode = searchPrefix
+			}
+		}
+	}
+
+	if mode == searchPrefix {
+		findOptions := options.Find()
+		page.Apply(findOptions)
+		findOptions.SetSort(bson.D{{Key: "title", Value: 1}, {Key: "id", Value: 1}})
+
+		prefix := bson.M{"title": bson.M{"$regex": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}}}
+		filter := bson.M{"$and": []bson.M{{"$and": conditions}, prefix}}
+		products, total, err = s.r.FindAndTotal(filter, findOptions, nil, sel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	products, next, err := query.Cut(page, products)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := sel.Trim(products)
+	if err != nil {
+		return nil, err
+	}
+
+	response, link := page.Envelope(data, total, next, c.URL())
+	response["mode"] = mode
+	c.SetHeader("Link", link)
 
 	return response, nil
 }
@@ -131,7 +260,7 @@ func (s *productService) InsertProduct(req CreateProductRequest) (interface{}, e
 
 	filter := bson.M{"_id": result}
 	findOptions := options.FindOneOptions{}
-	product, err := s.r.FindProduct(filter, &findOptions)
+	product, err := s.r.FindProduct(filter, &findOptions, query.All())
 	if err != nil {
 		return nil, err
 	}
@@ -145,16 +274,6 @@ func (s *productService) EventCreateProduct(c microservice.IContext, req CreateP
 		return "", err
 	}
 
-	sub, err := utils.RandomNanoID(11)
-	if err != nil {
-		return "", err
-	}
-	token, err := utils.GenerateToken(sub)
-	if err != nil {
-		return "", err
-	}
-	c.SetAuthorization(token)
-
 	document := CreateProductRequest{
 		ID:           id,
 		Type:         "products",
@@ -177,60 +296,86 @@ func (s *productService) EventCreateProduct(c microservice.IContext, req CreateP
 		}
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   document,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductCreated, id, header, document)
+	if err != nil {
+		return "", err
 	}
-
-	eventProducer := producer.NewEventProducer(s.producer)
-	if err := eventProducer.Produce("product.created", data); err != nil {
+	if err := s.outbox.Publish(id, msg); err != nil {
 		return "", err
 	}
 
 	return id, nil
 }
 
-func (s *productService) FindOne(c microservice.IContext) (*Product, error) {
+func (s *productService) FindOne(c microservice.IContext) (any, error) {
 	id := c.Param("id")
 	if id == "" {
 		return nil, fmt.Errorf("id is required")
 	}
-	filter := bson.M{"id": id, "deleteDate": nil}
-	findOptions := options.FindOneOptions{}
-	product, err := s.r.FindProduct(filter, &findOptions)
-
+	sel, err := productQuery.Parse(c.QueryString("fields"), c.QueryString("expand"))
 	if err != nil {
 		return nil, err
+	}
 
+	filter := bson.M{"id": id, "deleteDate": nil}
+	var product *Product
+	if value := c.QueryString("at"); value != "" {
+		at, err := utils.ParseTimestamp(value)
+		if err != nil {
+			return nil, err
+		}
+		product, err = s.r.FindProductAt(filter, at, sel)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		findOptions := options.FindOneOptions{}
+		product, err = s.r.FindProduct(filter, &findOptions, sel)
+		if err != nil {
+			return nil, err
+		}
+		c.SetHeader("ETag", strconv.Quote(strconv.FormatInt(product.Version, 10)))
 	}
-	return product, nil
+
+	return sel.Trim(product)
 }
 
-func (s *productService) EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (string, error) {
+// EventUpdateProduct publishes the update of a product. The update must be
+// based on the current version of the product; as the consumer applies it
+// asynchronously it may still be rejected, see Update.
+func (s *productService) EventUpdateProduct(c microservice.IContext, req UpdateProductRequest) (*Update, error) {
 	id := c.Param("id")
 	if id == "" {
-		return "", fmt.Errorf("id is required")
+		return nil, fmt.Errorf("id is required")
 	}
-	sub, err := utils.RandomNanoID(11)
+	version, err := basedOn(c, req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	token, err := utils.GenerateToken(sub)
+
+	product, err := s.r.FindProduct(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{}, query.All())
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if version != product.Version {
+		return nil, ErrVersionConflict
 	}
-	c.SetAuthorization(token)
 
 	document := UpdateProductRequest{
+		ID:           id,
+		Version:      &version,
+		Status:       req.Status,
 		Title:        req.Title,
 		ProductPrice: req.ProductPrice,
 		Description:  req.Description,
 		Image:        req.Image,
+		LastUpdate:   time.Now().UTC(),
 	}
 
-	if len(req.Category) > 0 {
-		for _, v := range req.Category {
+	if req.Category != nil {
+		categories := []Category{}
+		for _, v := range *req.Category {
 			category := Category{
 				ID:   v.ID,
 				Type: "category",
@@ -238,73 +383,147 @@ func (s *productService) EventUpdateProduct(c microservice.IContext, req UpdateP
 			if v.Name != "" {
 				category.Name = v.Name
 			}
-			document.Category = append(document.Category, category)
+			categories = append(categories, category)
 		}
+		document.Category = &categories
 	}
 
-	_, header := c.GetHeader()
-	data := Event{
-		Header: header,
-		Body:   document,
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductUpdated, id, header, document)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.outbox.Publish(id, msg); err != nil {
+		return nil, err
 	}
 
-	eventProducer := producer.NewEventProducer(s.producer)
-	if err := eventProducer.Produce("product.updated", data); err != nil {
-		return "", err
+	return &Update{
+		ID:      id,
+		EventID: msg.EventID,
+		Version: version,
+		Status:  UpdatePending,
+		Href:    updateHref(id, msg.EventID),
+	}, nil
+}
+
+// basedOn returns the version an update is based on, from If-Match or else
+// the body.
+func basedOn(c microservice.IContext, req UpdateProductRequest) (int64, error) {
+	match := c.RequestHeader("If-Match")
+	if match == "" {
+		if req.Version == nil {
+			return 0, ErrVersionRequired
+		}
+		return *req.Version, nil
 	}
+	return parseIfMatch(match)
+}
 
-	return id, nil
+// parseIfMatch returns the product version of an If-Match header.
+func parseIfMatch(match string) (int64, error) {
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(match, "W/"), `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: If-Match %s is not a product version", ErrInvalidVersion, match)
+	}
+	return version, nil
 }
 
+func updateHref(id, eventID string) string {
+	return utils.Href("products", id) + "/updates/" + eventID
+}
+
+// FindUpdate returns the status of an update published by
+// EventUpdateProduct. It was applied when the consumer handled its event,
+// and rejected when the product changed or was deleted before.
+func (s *productService) FindUpdate(c microservice.IContext) (*Update, error) {
+	id, eventID := c.Param("id"), c.Param("eventId")
+
+	version, err := s.r.FindUpdateVersion(id, eventID)
+	if err != nil {
+		return nil, err
+	}
+	processed, err := s.r.IsProcessed(eventID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.r.FindRevision(id)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &Update{
+		ID:      id,
+		EventID: eventID,
+		Version: version,
+		Status:  UpdatePending,
+		Href:    updateHref(id, eventID),
+	}
+	switch {
+	case processed || current.LastEventID == eventID:
+		update.Status = UpdateApplied
+	case current.DeleteDate != nil:
+		update.Status = UpdateRejected
+		update.Reason = "product has been deleted"
+	case current.Version > version:
+		update.Status = UpdateRejected
+		update.Reason = fmt.Sprintf("product has been modified, current version %d", current.Version)
+	}
+	return update, nil
+}
+
+// EventDeleteProduct publishes the deletion of a product and its prices. It
+// returns mongo.ErrNoDocuments when the product doesn't exist or is deleted
+// already, and ErrPreconditionFailed when If-Match is given and isn't its
+// current version.
 func (s *productService) EventDeleteProduct(c microservice.IContext) (string, error) {
 	id := c.Param("id")
 	if id == "" {
 		return "", fmt.Errorf("id is required")
 	}
 
-	sub, err := utils.RandomNanoID(11)
-	if err != nil {
-		return "", err
-	}
-	token, err := utils.GenerateToken(sub)
+	product, err := s.r.FindProduct(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{}, query.All())
 	if err != nil {
 		return "", err
 	}
-	c.SetAuthorization(token)
-
-	product, err := s.r.FindProduct(bson.M{"id": id, "deleteDate": nil}, &options.FindOneOptions{})
-	if err != nil {
-		return "", err
+	if match := c.RequestHeader("If-Match"); match != "" {
+		version, err := parseIfMatch(match)
+		if err != nil {
+			return "", err
+		}
+		if version != product.Version {
+			return "", ErrPreconditionFailed
+		}
 	}
 
 	document := DeleteProductRequest{
 		ID:         product.ID,
+		Version:    product.Version,
 		DeleteDate: time.Now().UTC(),
 	}
 
-	_, header := c.GetHeader()
-	productBody := Event{
-		Header: header,
-		Body:   document,
-	}
-	eventProducer := producer.NewEventProducer(s.producer)
-	if err := eventProducer.Produce("product.deleted", productBody); err != nil {
+	header := c.GetHeader()
+	msg, err := outbox.NewMessage(contracts.ProductDeleted, id, header, document)
+	if err != nil {
 		return "", err
 	}
+	messages := []outbox.Message{msg}
 
 	if len(product.ProductPrice) != 0 {
 		for _, v := range product.ProductPrice {
-			price := Event{
-				Header: header,
-				Body: DeleteProductPriceRequest{
-					ID:         v.ID,
-					DeleteDate: time.Now().UTC(),
-				}}
-			if err := eventProducer.Produce("productPrice.deleted", price); err != nil {
+			msg, err := outbox.NewMessage(contracts.ProductPriceDeleted, v.ID, header, DeleteProductPriceRequest{
+				ID:         v.ID,
+				DeleteDate: document.DeleteDate,
+			})
+			if err != nil {
 				return "", err
 			}
+			messages = append(messages, msg)
 		}
 	}
 
+	if err := s.outbox.Publish(id, messages...); err != nil {
+		return "", err
+	}
+
 	return id, nil
 }
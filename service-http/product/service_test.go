@@ -0,0 +1,221 @@
+package product
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/sing3demons/go-product-service/microservice"
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/query/querytest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memoryRepository lists products kept in memory. Only FindAndTotal and
+// FindProduct are implemented.
+type memoryRepository struct {
+	IProductRepository
+	products []Product
+}
+
+func (r *memoryRepository) FindAndTotal(filter bson.M, findOptions *options.FindOptions, after bson.M, sel *query.Selection) ([]Product, int64, error) {
+	docs, err := querytest.Documents(r.products)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter = bson.M{"$and": []bson.M{filter, {"deleteDate": nil}}}
+	all, err := querytest.Find(docs, filter, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if after != nil {
+		filter = bson.M{"$and": []bson.M{filter, after}}
+	}
+	found, err := querytest.Find(docs, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	products, err := querytest.Decode[Product](found)
+	return products, int64(len(all)), err
+}
+
+func (r *memoryRepository) FindProduct(filter bson.M, findOptions *options.FindOneOptions, sel *query.Selection) (*Product, error) {
+	docs, err := querytest.Documents(r.products)
+	if err != nil {
+		return nil, err
+	}
+	found, err := querytest.Find(docs, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	products, err := querytest.Decode[Product](found[:1])
+	if err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+// requestContext is the context of a request with the query values, path
+// parameters and request headers.
+type requestContext struct {
+	microservice.IContext
+	url     *url.URL
+	header  map[string]string
+	params  map[string]string
+	request map[string]string
+}
+
+func newRequestContext(values url.Values) *requestContext {
+	return &requestContext{
+		url:     &url.URL{Path: "/products", RawQuery: values.Encode()},
+		header:  map[string]string{},
+		params:  map[string]string{},
+		request: map[string]string{},
+	}
+}
+
+func (c *requestContext) Param(key string) string {
+	return c.params[key]
+}
+
+func (c *requestContext) RequestHeader(key string) string {
+	return c.request[key]
+}
+
+func (c *requestContext) QueryString(name string) string {
+	return c.url.Query().Get(name)
+}
+
+func (c *requestContext) URL() *url.URL {
+	return c.url
+}
+
+func (c *requestContext) SetHeader(key, value string) {
+	c.header[key] = value
+}
+
+// listIDs follows the next cursors of FindAll and returns the ids of the
+// products in the order they were listed.
+func listIDs(t *testing.T, svc IProductService, values url.Values) []string {
+	t.Helper()
+
+	var ids []string
+	for i := 0; i < 100; i++ {
+		response, err := svc.FindAll(newRequestContext(values))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := response.(map[string]any)
+		for _, p := range page["data"].([]Product) {
+			ids = append(ids, p.ID)
+		}
+
+		next, ok := page["next"].(string)
+		if !ok {
+			return ids
+		}
+		values.Set("cursor", next)
+	}
+	t.Fatal("no last page after 100 pages")
+	return nil
+}
+
+func TestFindAllPagesThroughProductsSortedByPrice(t *testing.T) {
+	repo := &memoryRepository{products: []Product{
+		{ID: "p1", CurrentPrice: &Price{Unit: "THB", Value: 10}, CurrentPrices: []Price{{Unit: "THB", Value: 10}}},
+		{ID: "p2", CurrentPrice: &Price{Unit: "THB", Value: 30}, CurrentPrices: []Price{{Unit: "THB", Value: 30}}},
+		{ID: "p3"},
+		{ID: "p4", CurrentPrices: []Price{{Unit: "THB", Value: 20}, {Unit: "USD", Value: 1}}},
+		{ID: "p5", CurrentPrice: &Price{Unit: "USD", Value: 5}, CurrentPrices: []Price{{Unit: "USD", Value: 5}}},
+		{ID: "p6", CurrentPrice: &Price{Unit: "THB", Value: 30}, CurrentPrices: []Price{{Unit: "THB", Value: 30}}},
+	}}
+	svc := NewProductService(repo, nil)
+
+	cases := []struct {
+		sort string
+		want []string
+	}{
+		{"price", []string{"p3", "p4", "p1", "p2", "p6", "p5"}},
+		{"-price", []string{"p5", "p6", "p2", "p1", "p4", "p3"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.sort, func(t *testing.T) {
+			for _, limit := range []string{"1", "2", "4", "100"} {
+				got := listIDs(t, svc, url.Values{"sort": {tc.sort}, "limit": {limit}})
+				if !slices.Equal(got, tc.want) {
+					t.Errorf("pages of %s listed %v, want %v", limit, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventDeleteProductRejects(t *testing.T) {
+	repo := &memoryRepository{products: []Product{{ID: "p1", Version: 3}}}
+	svc := NewProductService(repo, nil)
+
+	cases := []struct {
+		name    string
+		id      string
+		ifMatch string
+		want    error
+	}{
+		{name: "missing product", id: "p9", want: mongo.ErrNoDocuments},
+		{name: "other version", id: "p1", ifMatch: `"2"`, want: ErrPreconditionFailed},
+		{name: "malformed If-Match", id: "p1", ifMatch: "latest", want: ErrInvalidVersion},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newRequestContext(nil)
+			c.params["id"] = tc.id
+			if tc.ifMatch != "" {
+				c.request["If-Match"] = tc.ifMatch
+			}
+			if _, err := svc.EventDeleteProduct(c); !errors.Is(err, tc.want) {
+				t.Errorf("EventDeleteProduct() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFindAllFiltersOnTheEmbeddedPrices(t *testing.T) {
+	price := func(id, unit string, value float64) ProductPrice {
+		return ProductPrice{ID: id, Type: "productPrice", Price: &Price{Unit: unit, Value: value}}
+	}
+	repo := &memoryRepository{products: []Product{
+		{ID: "p1", ProductPrice: []ProductPrice{price("r1", "THB", 10)}},
+		{ID: "p2", ProductPrice: []ProductPrice{price("r2", "THB", 50), price("r3", "USD", 2)}},
+		{ID: "p3", ProductPrice: []ProductPrice{price("r4", "USD", 40)}},
+		{ID: "p4", ProductPrice: []ProductPrice{{ID: "r5", Type: "productPrice"}}},
+		{ID: "p5"},
+	}}
+	svc := NewProductService(repo, nil)
+
+	cases := []struct {
+		name   string
+		values url.Values
+		want   []string
+	}{
+		{name: "value", values: url.Values{"price.value[gte]": {"20"}}, want: []string{"p2", "p3"}},
+		{name: "unit", values: url.Values{"price.unit": {"USD"}}, want: []string{"p2", "p3"}},
+		{name: "value and unit of the same price", values: url.Values{"price.value[gte]": {"20"}, "price.unit": {"USD"}}, want: []string{"p3"}},
+		{name: "range", values: url.Values{"price.value[gt]": {"5"}, "price.value[lt]": {"45"}}, want: []string{"p1", "p3"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := listIDs(t, svc, tc.values)
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("listed %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sing3demons/go-product-service/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Reserved are the query parameters that never describe a filter.
+var Reserved = []string{"fields", "expand", "limit", "cursor", "page", "sort", "s", "at", "q", "mode"}
+
+type Kind int
+
+const (
+	String Kind = iota
+	Number
+	Time
+)
+
+var operators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"in":  "$in",
+	"nin": "$nin",
+}
+
+// FilterField allows filtering on Name with the listed operators. Field is
+// the document field, Name when empty. With Ref set the condition applies to
+// the referenced documents instead of the resource itself.
+type FilterField struct {
+	Name  string
+	Field string
+	Kind  Kind
+	Ops   []string
+	Ref   string
+}
+
+type FilterSpec []FilterField
+
+// Filter is the parsed form of field=value and field[op]=value parameters.
+type Filter struct {
+	Match bson.M
+	Refs  map[string]bson.M
+}
+
+// Parse builds a filter from the query parameters. Parameters that aren't
+// reserved must name an allowed field and operator; field=value is
+// field[eq]=value.
+func (spec FilterSpec) Parse(values url.Values) (*Filter, error) {
+	f := &Filter{Match: bson.M{}, Refs: map[string]bson.M{}}
+
+	for key, vs := range values {
+		if slices.Contains(Reserved, key) {
+			continue
+		}
+
+		name, op := key, "eq"
+		if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:i], key[i+1:len(key)-1]
+		}
+
+		field, ok := spec.field(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot filter on %q", ErrInvalidQuery, name)
+		}
+		if !slices.Contains(field.Ops, op) {
+			return nil, fmt.Errorf("%w: operator %q is not allowed on %q", ErrInvalidQuery, op, name)
+		}
+
+		for _, raw := range vs {
+			value, err := field.value(op, raw)
+			if err != nil {
+				return nil, err
+			}
+
+			match := f.Match
+			if field.Ref != "" {
+				if f.Refs[field.Ref] == nil {
+					f.Refs[field.Ref] = bson.M{}
+				}
+				match = f.Refs[field.Ref]
+			}
+
+			conditions, _ := match[field.Field].(bson.M)
+			if conditions == nil {
+				conditions = bson.M{}
+				match[field.Field] = conditions
+			}
+			if _, exists := conditions[operators[op]]; exists {
+				return nil, fmt.Errorf("%w: %s[%s] is given twice", ErrInvalidQuery, name, op)
+			}
+			conditions[operators[op]] = value
+		}
+	}
+
+	return f, nil
+}
+
+func (spec FilterSpec) field(name string) (FilterField, bool) {
+	for _, field := range spec {
+		if field.Name == name {
+			if field.Field == "" {
+				field.Field = field.Name
+			}
+			return field, true
+		}
+	}
+	return FilterField{}, false
+}
+
+func (field FilterField) value(op, raw string) (any, error) {
+	if op == "in" || op == "nin" {
+		var values []any
+		for _, v := range strings.Split(raw, ",") {
+			value, err := field.convert(strings.TrimSpace(v))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+	return field.convert(raw)
+}
+
+func (field FilterField) convert(raw string) (any, error) {
+	switch field.Kind {
+	case Number:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidQuery, field.Name)
+		}
+		return n, nil
+	case Time:
+		t, err := utils.ParseTimestamp(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidQuery, field.Name, err)
+		}
+		return t, nil
+	default:
+		return raw, nil
+	}
+}
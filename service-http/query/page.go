@@ -0,0 +1,263 @@
+package query
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sing3demons/go-product-service/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultPageSize = 9
+	MaxPageSize     = 100
+)
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// DefaultSort orders the most recently updated documents first.
+var DefaultSort = []SortKey{{Field: "lastUpdate", Desc: true}}
+
+// Page describes which slice of a collection is returned. Documents are
+// always ordered by Sort followed by id, so every page boundary can be
+// expressed as a cursor holding the sort values of the last document.
+type Page struct {
+	Limit  int64
+	Number int64
+	Sort   []SortKey
+
+	after  []any
+	offset bool
+}
+
+// ParsePage validates the limit, cursor and page parameters. page is kept for
+// existing clients; cursor wins when both are given. Limits above
+// MaxPageSize are capped.
+func ParsePage(limit, cursor, page string, sort []SortKey) (*Page, error) {
+	p := &Page{Limit: DefaultPageSize}
+	if limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%w: limit must be a positive number", ErrInvalidQuery)
+		}
+		p.Limit = min(n, MaxPageSize)
+	}
+
+	if len(sort) == 0 {
+		sort = DefaultSort
+	}
+	for _, key := range sort {
+		if key.Field != "id" {
+			p.Sort = append(p.Sort, key)
+		}
+	}
+	p.Sort = append(p.Sort, SortKey{Field: "id", Desc: sort[len(sort)-1].Desc})
+
+	if cursor != "" {
+		after, err := p.decode(cursor)
+		if err != nil {
+			return nil, err
+		}
+		p.after = after
+		return p, nil
+	}
+
+	p.Number = 1
+	if page != "" {
+		n, err := strconv.ParseInt(page, 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%w: page must be a positive number", ErrInvalidQuery)
+		}
+		p.Number = n
+	}
+	return p, nil
+}
+
+// ParseOffsetPage is ParsePage for results that have no stable sort values to
+// build a cursor from, such as relevance ranked search results. Pages are
+// only addressed by number.
+func ParseOffsetPage(limit, cursor, page string) (*Page, error) {
+	if cursor != "" {
+		return nil, fmt.Errorf("%w: cursor is not supported here, use page", ErrInvalidQuery)
+	}
+	p, err := ParsePage(limit, "", page, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.offset = true
+	return p, nil
+}
+
+// Fields returns the fields the page is ordered by. They have to be part of
+// any projection so the next cursor can be built.
+func (p *Page) Fields() []string {
+	var fields []string
+	for _, key := range p.Sort {
+		fields = append(fields, key.Field)
+	}
+	return fields
+}
+
+// Apply sets the sort, skip and limit of findOptions. One document more than
+// the limit is requested to find out whether there is a next page.
+func (p *Page) Apply(findOptions *options.FindOptions) {
+	sort := bson.D{}
+	for _, key := range p.Sort {
+		order := 1
+		if key.Desc {
+			order = -1
+		}
+		sort = append(sort, bson.E{Key: key.Field, Value: order})
+	}
+	findOptions.SetSort(sort)
+	findOptions.SetLimit(p.Limit + 1)
+	if p.Number > 1 {
+		findOptions.SetSkip((p.Number - 1) * p.Limit)
+	}
+}
+
+// After returns the filter selecting the documents behind the cursor, or nil
+// without a cursor. Missing values sort before any other value, so they come
+// last in descending order, and $lt and $gt never match them.
+func (p *Page) After() bson.M {
+	if p.after == nil {
+		return nil
+	}
+
+	var or []bson.M
+	for i, key := range p.Sort {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[p.Sort[j].Field] = p.after[j]
+		}
+
+		switch {
+		case p.after[i] == nil && key.Desc:
+			continue
+		case p.after[i] == nil:
+			condition[key.Field] = bson.M{"$ne": nil}
+		case key.Desc:
+			condition["$or"] = []bson.M{
+				{key.Field: bson.M{"$lt": p.after[i]}},
+				{key.Field: nil},
+			}
+		default:
+			condition[key.Field] = bson.M{"$gt": p.after[i]}
+		}
+		or = append(or, condition)
+	}
+	return bson.M{"$or": or}
+}
+
+// Cut drops the extra document requested by Apply and returns the cursor of
+// the next page, or its number for offset pages, empty on the last page.
+func Cut[T any](p *Page, items []T) ([]T, string, error) {
+	if int64(len(items)) <= p.Limit {
+		return items, "", nil
+	}
+
+	items = items[:p.Limit]
+	if p.offset {
+		return items, strconv.FormatInt(p.Number+1, 10), nil
+	}
+	next, err := p.encode(items[len(items)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// Envelope wraps data with the paging metadata and returns it together with
+// the value of the Link header.
+func (p *Page) Envelope(data any, total int64, next string, self *url.URL) (map[string]any, string) {
+	links := map[string]string{"self": link(self, nil)}
+	header := fmt.Sprintf(`<%s>; rel="self"`, links["self"])
+	if next != "" {
+		param := "cursor"
+		if p.offset {
+			param = "page"
+		}
+		links["next"] = link(self, map[string]string{param: next})
+		header += fmt.Sprintf(`, <%s>; rel="next"`, links["next"])
+	}
+
+	response := map[string]any{
+		"data":  data,
+		"total": total,
+		"limit": p.Limit,
+		"links": links,
+	}
+	if next != "" && !p.offset {
+		response["next"] = next
+	}
+	if p.Number != 0 {
+		response["page"] = p.Number
+		response["last_page"] = int64(math.Ceil(float64(total) / float64(p.Limit)))
+	}
+	return response, header
+}
+
+func link(self *url.URL, set map[string]string) string {
+	values := self.Query()
+	if len(set) != 0 {
+		values.Del("page")
+		values.Del("cursor")
+	}
+	for k, v := range set {
+		values.Set(k, v)
+	}
+
+	uri := utils.HostURL() + self.Path
+	if query := values.Encode(); query != "" {
+		uri += "?" + query
+	}
+	return uri
+}
+
+type cursor struct {
+	Values []any `bson:"v"`
+}
+
+func (p *Page) encode(last any) (string, error) {
+	raw, err := bson.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+
+	c := cursor{}
+	for _, field := range p.Fields() {
+		value, err := bson.Raw(raw).LookupErr(strings.Split(field, ".")...)
+		if err != nil {
+			c.Values = append(c.Values, nil)
+			continue
+		}
+		c.Values = append(c.Values, value)
+	}
+
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (p *Page) decode(value string) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	var c cursor
+	if err := bson.Unmarshal(data, &c); err != nil || len(c.Values) != len(p.Sort) {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c.Values, nil
+}
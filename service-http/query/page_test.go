@@ -0,0 +1,93 @@
+package query_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sing3demons/go-product-service/query"
+	"github.com/sing3demons/go-product-service/query/querytest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// pageThrough follows the cursors of pages of limit documents and returns the
+// ids in the order they were returned.
+func pageThrough(t *testing.T, docs []bson.M, limit string, sort []query.SortKey) []string {
+	t.Helper()
+
+	var ids []string
+	cursor := ""
+	for i := 0; i <= len(docs); i++ {
+		page, err := query.ParsePage(limit, cursor, "", sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		findOptions := options.Find()
+		page.Apply(findOptions)
+		filter := page.After()
+		if filter == nil {
+			filter = bson.M{}
+		}
+
+		found, err := querytest.Find(docs, filter, findOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, next, err := query.Cut(page, found)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range found {
+			ids = append(ids, doc["id"].(string))
+		}
+		if next == "" {
+			return ids
+		}
+		cursor = next
+	}
+	t.Fatalf("no last page after %d pages", len(docs)+1)
+	return nil
+}
+
+func TestPageAfterKeepsDocumentsWithoutTheSortField(t *testing.T) {
+	docs := []bson.M{
+		{"id": "a", "price": bson.M{"unit": "THB", "value": 30}},
+		{"id": "b"},
+		{"id": "c", "price": bson.M{"unit": "THB", "value": 10}},
+		{"id": "d", "price": nil},
+		{"id": "e", "price": bson.M{"unit": "USD", "value": 10}},
+		{"id": "f", "price": bson.M{"unit": "THB", "value": 30}},
+		{"id": "g"},
+		{"id": "h", "price": bson.M{"unit": "THB"}},
+	}
+
+	cases := []struct {
+		name string
+		sort string
+	}{
+		{"ascending", "price"},
+		{"descending", "-price"},
+		{"descending then ascending", "-price,title"},
+		{"ascending then descending", "title,-price"},
+	}
+
+	spec := query.SortSpec{"price": "price.unit,price.value", "title": "title"}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sort, err := spec.Parse(tc.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := pageThrough(t, docs, "100", sort)
+			if len(want) != len(docs) {
+				t.Fatalf("one page has %d documents, want %d", len(want), len(docs))
+			}
+			for _, limit := range []string{"1", "2", "3"} {
+				if got := pageThrough(t, docs, limit, sort); !slices.Equal(got, want) {
+					t.Errorf("pages of %s returned %v, want %v", limit, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+// Package querytest evaluates the filters and find options the query package
+// builds against documents in memory, the way Mongo does, for tests of code
+// paging through collections.
+//
+// Only what the query package and the services generate is supported:
+// $and, $or, equality, $ne, $in, $lt, $lte, $gt and $gte on numbers, strings
+// and dates, and $elemMatch of arrays of documents. Like in Mongo, a field compared with nil matches a missing field
+// too, the comparisons only match values of the same type, and missing and
+// null values sort before any other.
+package querytest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Documents converts items, such as the structs a repository returns, to the
+// documents they are stored as.
+func Documents[T any](items []T) ([]bson.M, error) {
+	docs := make([]bson.M, 0, len(items))
+	for _, item := range items {
+		data, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var doc bson.M
+		if err := bson.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// Decode converts docs back to T.
+func Decode[T any](docs []bson.M) ([]T, error) {
+	items := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var item T
+		if err := bson.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Find returns the docs matching filter, sorted, skipped and limited by
+// findOptions.
+func Find(docs []bson.M, filter bson.M, findOptions *options.FindOptions) ([]bson.M, error) {
+	var found []bson.M
+	for _, doc := range docs {
+		ok, err := Match(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, doc)
+		}
+	}
+
+	if findOptions == nil {
+		return found, nil
+	}
+	if findOptions.Sort != nil {
+		sort, ok := findOptions.Sort.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("querytest: sort %T is not a bson.D", findOptions.Sort)
+		}
+		slices.SortStableFunc(found, func(a, b bson.M) int {
+			for _, key := range sort {
+				c := compare(lookup(a, key.Key), lookup(b, key.Key))
+				if order, _ := key.Value.(int); order < 0 {
+					c = -c
+				}
+				if c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+	}
+	if findOptions.Skip != nil {
+		found = found[min(int(*findOptions.Skip), len(found)):]
+	}
+	if findOptions.Limit != nil && *findOptions.Limit > 0 {
+		found = found[:min(int(*findOptions.Limit), len(found))]
+	}
+	return found, nil
+}
+
+// Match reports whether doc matches filter.
+func Match(doc bson.M, filter bson.M) (bool, error) {
+	for key, value := range filter {
+		var (
+			ok  bool
+			err error
+		)
+		switch key {
+		case "$and", "$or":
+			ok, err = matchAll(doc, key, value)
+		default:
+			ok, err = matchField(lookup(doc, key), value)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchAll(doc bson.M, op string, value any) (bool, error) {
+	filters, ok := value.([]bson.M)
+	if !ok {
+		return false, fmt.Errorf("querytest: %s of %T", op, value)
+	}
+	for _, f := range filters {
+		ok, err := Match(doc, f)
+		if err != nil {
+			return false, err
+		}
+		if op == "$or" && ok {
+			return true, nil
+		}
+		if op == "$and" && !ok {
+			return false, nil
+		}
+	}
+	return op == "$and", nil
+}
+
+func matchField(field, condition any) (bool, error) {
+	operators, ok := condition.(bson.M)
+	if !ok {
+		return equal(field, condition), nil
+	}
+
+	for op, operand := range operators {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = equal(field, operand)
+		case "$ne":
+			ok = !equal(field, operand)
+		case "$in":
+			values, isSlice := operand.([]any)
+			if !isSlice {
+				return false, fmt.Errorf("querytest: $in of %T", operand)
+			}
+			ok = slices.ContainsFunc(values, func(v any) bool { return equal(field, v) })
+		case "$lt", "$lte", "$gt", "$gte":
+			field, operand := normalize(field), normalize(operand)
+			if field == nil || operand == nil || rank(field) != rank(operand) {
+				return false, nil
+			}
+			c := compare(field, operand)
+			ok = op == "$lt" && c < 0 || op == "$lte" && c <= 0 || op == "$gt" && c > 0 || op == "$gte" && c >= 0
+		case "$elemMatch":
+			filter, isFilter := operand.(bson.M)
+			if !isFilter {
+				return false, fmt.Errorf("querytest: $elemMatch of %T", operand)
+			}
+			elements, _ := field.(primitive.A)
+			for _, element := range elements {
+				doc, isDoc := element.(bson.M)
+				if d, isD := element.(primitive.D); isD {
+					doc, isDoc = d.Map(), true
+				}
+				if !isDoc {
+					continue
+				}
+				matched, err := Match(doc, filter)
+				if err != nil {
+					return false, err
+				}
+				if matched {
+					ok = true
+					break
+				}
+			}
+		default:
+			return false, fmt.Errorf("querytest: unsupported operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func equal(a, b any) bool {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return rank(a) == rank(b) && compare(a, b) == 0
+}
+
+// lookup returns the value of the dotted path in doc, nil when it is missing.
+func lookup(doc bson.M, path string) any {
+	var value any = doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case bson.M:
+			value = v[key]
+		case primitive.D:
+			value = v.Map()[key]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// normalize turns numbers into float64 and dates into time.Time.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case time.Time:
+		return v.UTC()
+	}
+	return v
+}
+
+// rank is the position of the type of v in the sort order of Mongo.
+func rank(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case time.Time:
+		return 3
+	}
+	return 4
+}
+
+func compare(a, b any) int {
+	a, b = normalize(a), normalize(b)
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case float64:
+		switch b := b.(float64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
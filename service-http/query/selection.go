@@ -0,0 +1,173 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidQuery = errors.New("invalid query parameter")
+
+// ExpandNone disables the enrichment of every reference.
+const ExpandNone = "none"
+
+// alwaysSelected are returned whatever fields were asked for, so every
+// entity stays addressable and search results keep their relevance.
+var alwaysSelected = []string{"id", "@type", "href", "score"}
+
+// Spec lists what a resource allows to select with ?fields= and to
+// expand with ?expand=. Names are the json names of the response.
+type Spec struct {
+	Fields []string
+	Expand []string
+}
+
+// Selection is the parsed form of ?fields= and ?expand=.
+type Selection struct {
+	Fields []string
+	expand map[string]bool
+
+	required []string
+}
+
+// Parse validates the raw fields and expand parameters. Empty fields selects
+// everything and empty expand expands every reference, like before these
+// parameters existed.
+func (spec Spec) Parse(fields, expand string) (*Selection, error) {
+	s := &Selection{expand: map[string]bool{}}
+
+	for _, field := range split(fields) {
+		if !slices.Contains(spec.Fields, field) && !slices.Contains(alwaysSelected, field) {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+		}
+		s.Fields = append(s.Fields, field)
+	}
+
+	names := split(expand)
+	if len(names) == 0 {
+		names = spec.Expand
+	}
+	for _, name := range names {
+		if name == ExpandNone {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("%w: expand=%s cannot be combined", ErrInvalidQuery, ExpandNone)
+			}
+			continue
+		}
+		if !slices.Contains(spec.Expand, name) {
+			return nil, fmt.Errorf("%w: cannot expand %q", ErrInvalidQuery, name)
+		}
+		s.expand[name] = true
+	}
+
+	return s, nil
+}
+
+// All selects every field and expands nothing. Use it when only the stored
+// references are needed.
+func All() *Selection {
+	return &Selection{expand: map[string]bool{}}
+}
+
+// Require keeps fields in the projection without returning them, e.g. the
+// fields a page is sorted by.
+func (s *Selection) Require(fields ...string) {
+	s.required = append(s.required, fields...)
+}
+
+// Selected reports whether field is part of the response.
+func (s *Selection) Selected(field string) bool {
+	return len(s.Fields) == 0 || slices.Contains(s.Fields, field) || slices.Contains(alwaysSelected, field)
+}
+
+// Expands reports whether the reference name has to be resolved. A reference
+// that isn't selected is never expanded.
+func (s *Selection) Expands(name string) bool {
+	return s.expand[name] && s.Selected(name)
+}
+
+// Projection returns the Mongo projection for the selected fields, or nil when
+// every field is selected. The fields href is computed from are always kept.
+func (s *Selection) Projection() bson.D {
+	if len(s.Fields) == 0 {
+		return nil
+	}
+
+	projection := bson.D{{Key: "id", Value: 1}, {Key: "@type", Value: 1}}
+	seen := map[string]bool{"id": true, "@type": true, "href": true, "score": true}
+	for _, field := range append(slices.Clone(s.Fields), s.required...) {
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+	return projection
+}
+
+// Apply sets the projection of findOptions, keeping the computed fields
+// findOptions already projects.
+func (s *Selection) Apply(findOptions *options.FindOptions) {
+	projection := s.Projection()
+	if projection == nil {
+		return
+	}
+	if existing, ok := findOptions.Projection.(bson.D); ok {
+		projection = append(projection, existing...)
+	}
+	findOptions.SetProjection(projection)
+}
+
+// Trim drops the unselected fields from v, a single entity or a slice of them.
+func (s *Selection) Trim(v any) (any, error) {
+	if len(s.Fields) == 0 {
+		return v, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	switch value := decoded.(type) {
+	case []any:
+		for _, item := range value {
+			s.trim(item)
+		}
+	default:
+		s.trim(value)
+	}
+	return decoded, nil
+}
+
+func (s *Selection) trim(v any) {
+	item, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+	for key := range item {
+		if !s.Selected(key) {
+			delete(item, key)
+		}
+	}
+}
+
+func split(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,49 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SortSpec maps the names accepted by ?sort= to document fields. A name may
+// sort by several fields, comma separated, such as the unit of a price before
+// its value so prices in different units are never compared.
+type SortSpec map[string]string
+
+// Parse reads sort=field,-field. A leading "-" sorts descending. The legacy
+// sort=asc and sort=desc order by price where the resource has one.
+func (spec SortSpec) Parse(value string) ([]SortKey, error) {
+	if value == "asc" || value == "desc" {
+		fields, ok := spec["price"]
+		if !ok {
+			return nil, nil
+		}
+		var keys []SortKey
+		for _, field := range strings.Split(fields, ",") {
+			keys = append(keys, SortKey{Field: field, Desc: value == "desc"})
+		}
+		return keys, nil
+	}
+
+	var keys []SortKey
+	seen := map[string]bool{}
+	for _, name := range split(value) {
+		desc := false
+		if after, ok := strings.CutPrefix(name, "-"); ok {
+			name, desc = after, true
+		}
+
+		fields, ok := spec[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, name)
+		}
+		for _, field := range strings.Split(fields, ",") {
+			if seen[field] {
+				return nil, fmt.Errorf("%w: %q is sorted by twice", ErrInvalidQuery, name)
+			}
+			seen[field] = true
+			keys = append(keys, SortKey{Field: field, Desc: desc})
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DefaultBatchSize   = 100
+	DefaultConcurrency = 4
+)
+
+type FetchFunc[T any] func(ctx context.Context, ids []string) ([]T, error)
+
+// Loader resolves documents by id. Ids are deduplicated and fetched in
+// batches, at most Concurrency batches at a time, and every result is cached
+// for the lifetime of the loader, so create one per request.
+type Loader[T any] struct {
+	BatchSize   int
+	Concurrency int
+
+	fetch FetchFunc[T]
+	key   func(T) string
+
+	mu    sync.Mutex
+	cache map[string]*T
+}
+
+func NewLoader[T any](fetch FetchFunc[T], key func(T) string) *Loader[T] {
+	return &Loader[T]{
+		BatchSize:   DefaultBatchSize,
+		Concurrency: DefaultConcurrency,
+		fetch:       fetch,
+		key:         key,
+		cache:       map[string]*T{},
+	}
+}
+
+// LoadMany returns the documents found for ids keyed by id. Ids without a
+// document are missing from the result.
+func (l *Loader[T]) LoadMany(ctx context.Context, ids []string) (map[string]T, error) {
+	if err := l.prime(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make(map[string]T, len(ids))
+	for _, id := range ids {
+		if doc := l.cache[id]; doc != nil {
+			result[id] = *doc
+		}
+	}
+	return result, nil
+}
+
+func (l *Loader[T]) prime(ctx context.Context, ids []string) error {
+	l.mu.Lock()
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if _, ok := l.cache[id]; ok || seen[id] || id == "" {
+			continue
+		}
+		seen[id] = true
+		missing = append(missing, id)
+	}
+	l.mu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, max(l.Concurrency, 1))
+	batchSize := max(l.BatchSize, 1)
+	for start := 0; start < len(missing); start += batchSize {
+		batch := missing[start:min(start+batchSize, len(missing))]
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			docs, err := l.fetch(ctx, batch)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, id := range batch {
+				l.cache[id] = nil
+			}
+			for _, doc := range docs {
+				doc := doc
+				l.cache[l.key(doc)] = &doc
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// FindByID fetches the non-deleted documents of collection whose id is in ids.
+func FindByID[T any](collection *mongo.Collection) FetchFunc[T] {
+	return func(ctx context.Context, ids []string) ([]T, error) {
+		cur, err := collection.Find(ctx, bson.M{
+			"id":         bson.M{"$in": ids},
+			"deleteDate": nil,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var result []T
+		if err := cur.All(ctx, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
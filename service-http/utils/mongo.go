@@ -9,7 +9,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func GetMultiWithTotal[T any](collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions) ([]T, int64, error) {
+// GetMultiWithTotal returns the documents matching filter and the number of
+// them. The after filters narrow the returned documents but not the total.
+func GetMultiWithTotal[T any](collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions, after ...bson.M) ([]T, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -31,8 +33,15 @@ func GetMultiWithTotal[T any](collection *mongo.Collection, filter bson.M, findO
 	}()
 	total = <-countCh
 
+	conditions := []bson.M{filter}
+	for _, f := range after {
+		if f != nil {
+			conditions = append(conditions, f)
+		}
+	}
+
 	var result []T
-	cur, err := collection.Find(ctx, filter, findOptions)
+	cur, err := collection.Find(ctx, bson.M{"$and": conditions}, findOptions)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -1,10 +1,11 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,9 +16,19 @@ func ConvertToObjectID(id any) (primitive.ObjectID, error) {
 	return primitive.ObjectIDFromHex(fmt.Sprintf("%s", id))
 }
 
+var hostURL string
+
+// SetHostURL sets the URL links returned by Href and HostURL start with.
+func SetHostURL(url string) {
+	hostURL = url
+}
+
+func HostURL() string {
+	return hostURL
+}
+
 func Href(typeName string, id string) string {
-	uri := os.Getenv("HOST_URL")
-	return uri + fmt.Sprintf("/%s/%s", typeName, id)
+	return hostURL + fmt.Sprintf("/%s/%s", typeName, id)
 }
 
 func GetHeaders(ctx *gin.Context) map[string]any {
@@ -79,3 +90,14 @@ type MacIP struct {
 	HardwareAddr  string   `json:"hardware_addr"`
 	IPs           []string `json:"ips"`
 }
+
+var ErrInvalidTimestamp = errors.New("invalid timestamp, expected RFC3339 or YYYY-MM-DD")
+
+func ParseTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, value)
+}
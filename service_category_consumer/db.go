@@ -2,48 +2,36 @@ package main
 
 import (
 	"context"
-	"log"
-	"os"
 	"time"
 
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/mongodb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func ConnectMonoDB() (*mongo.Database, error) {
-	dsn := os.Getenv("MONGO_URI")
-	if dsn == "" {
-		dsn = "mongodb://localhost:27017/my_app?authSource=admin"
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+func ConnectMonoDB(cfg mongodb.Config, ledgerTTL time.Duration) (*mongo.Database, error) {
+	db, err := mongodb.Connect(cfg)
 	if err != nil {
 		return nil, err
 	}
+	createIndexes(db, "", ledgerTTL)
 
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		return nil, err
-	}
+	return db, nil
+}
 
+// collections are the projections of the consumed topics, see replay.
+var collections = []string{"category", "categoryProcessedEvent"}
+
+// createIndexes creates the indexes of the collections named with suffix.
+// Processed events expire after ledgerTTL.
+func createIndexes(db *mongo.Database, suffix string, ledgerTTL time.Duration) {
 	indexModel := mongo.IndexModel{
 		Keys: bson.D{{Key: "id", Value: 1}},
 	}
-	client.Database("my_app").Collection("category").Indexes().CreateOne(context.TODO(), indexModel)
-
-	return client.Database("my_app"), nil
-}
-
-func DisconnectMongo(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	err := client.Disconnect(ctx)
-	if err != nil {
-		log.Fatal(err)
+	pageIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "lastUpdate", Value: -1}, {Key: "id", Value: -1}},
 	}
+	db.Collection(kafka.Shadow("category", suffix)).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{indexModel, pageIndexModel})
+	kafka.CreateLedgerIndexes(context.TODO(), db.Collection(kafka.Shadow("categoryProcessedEvent", suffix)), ledgerTTL)
 }
@@ -4,8 +4,8 @@ go 1.21.1
 
 require (
 	github.com/IBM/sarama v1.42.1
-	github.com/golang-jwt/jwt/v5 v5.1.0
-	github.com/joho/godotenv v1.5.1
+	github.com/golang-jwt/jwt/v5 v5.1.0 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/sirupsen/logrus v1.9.3
 	go.mongodb.org/mongo-driver v1.13.0
 )
@@ -37,4 +37,9 @@ require (
 	golang.org/x/sync v0.4.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+require github.com/sing3demons/go-platform v0.0.0
+
+replace github.com/sing3demons/go-platform => ../platform
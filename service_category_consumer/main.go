@@ -2,106 +2,90 @@ package main
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"strings"
-	"sync"
-	"syscall"
 
-	"github.com/IBM/sarama"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/sing3demons/go-category-service/repository"
 	"github.com/sing3demons/go-category-service/service"
+	"github.com/sing3demons/go-platform/auth"
+	"github.com/sing3demons/go-platform/config"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/logging"
+	"github.com/sing3demons/go-platform/mongodb"
 )
 
-func init() {
-	godotenv.Load(".env")
+// Config is the configuration of the consumer, see config.Load.
+type Config struct {
+	Log      logging.Config       `yaml:"log"`
+	Mongo    mongodb.Config       `yaml:"mongo"`
+	Kafka    kafka.Config         `yaml:"kafka"`
+	Consumer kafka.ConsumerConfig `yaml:"consumer"`
+	Auth     auth.VerifierConfig  `yaml:"auth"`
 }
 
 func main() {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.InfoLevel)
+	replay, opts := kafka.ReplayFlags()
+	cfg := Config{Consumer: kafka.ConsumerConfig{GroupID: "category-service"}}
+	config.Load(&cfg)
+	auth.ConfigureVerifier(cfg.Auth)
 
-	config := sarama.NewConfig()
-	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRange()
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	version, _ := sarama.ParseKafkaVersion("1.0.0")
-	config.Version = version
+	logger := logging.New(cfg.Log)
+	servers := cfg.Kafka.Brokers
 
-	broker := os.Getenv("KAFKA_BROKERS")
-	var servers []string
-	if broker != "" {
-		servers = strings.Split(broker, ",")
-	} else {
-		servers = []string{"localhost:9092"}
-	}
-
-	groupID := "category-service"
-	consumer, err := sarama.NewConsumerGroup(servers, groupID, config)
-	if err != nil {
-		panic(err)
-	}
-	defer consumer.Close()
-
-	db, err := ConnectMonoDB()
+	groupID := cfg.Consumer.GroupID
+	db, err := ConnectMonoDB(cfg.Mongo, cfg.Consumer.LedgerTTL())
 	if err != nil {
 		panic(err)
 	}
 
+	policy := cfg.Consumer.Retry
 	topics := []string{
 		"category.created",
 		"category.deleted",
 		"category.updated",
 	}
 
-	repo := repository.NewCategory(db, logger)
-	serviceCategory := service.NewCategoryEventHandler(repo, logger)
-	consumerHandler := service.NewConsumerHandler(serviceCategory)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	logger.Info("Category consumer started...")
-	go func() {
-		defer wg.Done()
-		for {
-			if err := consumer.Consume(ctx, topics, consumerHandler); err != nil {
-				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
-					return
-				}
-				log.Panicf("Error from consumer: %v", err)
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				return
-			}
+	if *replay {
+		err := kafka.Replay{
+			Brokers:     servers,
+			GroupID:     groupID,
+			Topics:      topics,
+			DB:          db,
+			Collections: collections,
+			Policy:      policy,
+			Logger:      logger,
+			Indexes: func(db *mongo.Database, suffix string) {
+				createIndexes(db, suffix, cfg.Consumer.LedgerTTL())
+			},
+			Handler: func(suffix string) kafka.EventHandler {
+				return newEventHandler(db, suffix, cfg.Consumer.ReplayPolicy, logger)
+			},
+		}.Run(*opts)
+		if err != nil {
+			logger.WithFields(logrus.Fields{"error": err}).Error("replay failed")
+			os.Exit(1)
 		}
-	}()
-
-	// Handle graceful shutdown
-	sigusr1 := make(chan os.Signal, 1)
-	signal.Notify(sigusr1, syscall.SIGUSR1)
-
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case <-sigterm:
-		fmt.Println("Received termination signal. Initiating shutdown...")
-		cancel()
-	case <-ctx.Done():
-		fmt.Println("terminating: context cancelled")
+		return
+	}
 
+	if err := kafka.CheckSwap(context.Background(), db); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Error("consumer not started")
+		os.Exit(1)
 	}
-	// Wait for the consumer to finish processing
-	wg.Wait()
+	if err := kafka.Run(context.Background(), cfg.Kafka, cfg.Consumer, topics, newEventHandler(db, "", "", logger), logger); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Error("consumer failed")
+		os.Exit(1)
+	}
+}
+
+// newEventHandler handles events on the collections named with suffix. Only
+// events signed by a service token with a catalog scope are accepted.
+// replayPolicy is empty for live consumption, see kafka.Authenticate.
+func newEventHandler(db *mongo.Database, suffix string, replayPolicy string, logger *logrus.Logger) kafka.EventHandler {
+	repo := repository.NewCategory(db, suffix, logger)
+	serviceCategory := service.NewCategoryEventHandler(repo, logger)
+	ledger := kafka.NewLedger(db.Collection(kafka.Shadow("categoryProcessedEvent", suffix)))
+	return kafka.Idempotent(ledger, kafka.Authenticate(kafka.Contract(serviceCategory, logger), replayPolicy, logger), logger)
 }
@@ -19,6 +19,11 @@ type UpdateCategoryReq struct {
 	LastUpdate time.Time    `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
 }
 
+type DeleteCategoryReq struct {
+	ID         string    `json:"id" bson:"id"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
+}
+
 type AddProduct struct {
 	ID   string `json:"id,omitempty" bson:"id,omitempty"`
 	Name string `json:"name,omitempty" bson:"name,omitempty"`
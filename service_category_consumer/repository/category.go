@@ -5,32 +5,41 @@ import (
 	"time"
 
 	"github.com/sing3demons/go-category-service/model"
+	"github.com/sing3demons/go-platform/kafka"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type category struct {
 	*mongo.Database
+	suffix string
 	logger *logrus.Logger
 }
 
-func NewCategory(db *mongo.Database, logger *logrus.Logger) CategoryRepository {
-	return &category{db, logger}
+// NewCategory returns a repository on the category collection named with
+// suffix, see kafka.Shadow.
+func NewCategory(db *mongo.Database, suffix string, logger *logrus.Logger) CategoryRepository {
+	return &category{db, suffix, logger}
 }
 
 type CategoryRepository interface {
-	Save(doc model.CreateCategoryReq) error
-	Update(req model.UpdateCategoryReq) (category *model.Category, err error)
+	Save(ctx context.Context, doc model.CreateCategoryReq) error
+	Update(ctx context.Context, req model.UpdateCategoryReq) (category *model.Category, err error)
+	Delete(ctx context.Context, req model.DeleteCategoryReq) (category *model.Category, err error)
 }
 
-func (tx *category) Save(doc model.CreateCategoryReq) error {
-	dbName := "category"
+func (tx *category) Save(ctx context.Context, doc model.CreateCategoryReq) error {
+	dbName := kafka.Shadow("category", tx.suffix)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	r, err := tx.Database.Collection(dbName).InsertOne(ctx, doc)
+	// upsert on the business id so a re-delivered event never duplicates a category
+	r, err := tx.Database.Collection(dbName).UpdateOne(ctx, bson.M{"id": doc.ID}, bson.M{
+		"$setOnInsert": doc,
+	}, options.Update().SetUpsert(true))
 	if err != nil {
 		tx.logger.WithFields(logrus.Fields{
 			"dbName": dbName,
@@ -43,31 +52,31 @@ func (tx *category) Save(doc model.CreateCategoryReq) error {
 	tx.logger.WithFields(logrus.Fields{
 		"dbName":   dbName,
 		"data":     doc,
-		"resultID": r.InsertedID,
+		"resultID": r.UpsertedID,
 	}).Debug("insert category success")
 	return nil
 }
 
-func (tx *category) Update(req model.UpdateCategoryReq) (category *model.Category, err error) {
-	dbName := "category"
+func (tx *category) Update(ctx context.Context, req model.UpdateCategoryReq) (category *model.Category, err error) {
+	dbName := kafka.Shadow("category", tx.suffix)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{"id": req.ID, "deleteDate": nil}
-	var update model.UpdateCategoryReq
-	update.ID = req.ID
+	update := bson.M{"lastUpdate": req.LastUpdate}
 	if req.Name != "" {
-		update.Name = req.Name
+		update["name"] = req.Name
 	}
 	if req.Status != "" {
-		update.Status = req.Status
+		update["status"] = req.Status
 	}
 	if req.LastUpdate.IsZero() {
-		update.LastUpdate = time.Now().UTC()
+		update["lastUpdate"] = time.Now().UTC()
 	}
 
 	if len(req.Products) > 0 {
+		products := []model.AddProduct{}
 		for _, v := range req.Products {
 			product := model.AddProduct{
 				ID:   v.ID,
@@ -76,18 +85,20 @@ func (tx *category) Update(req model.UpdateCategoryReq) (category *model.Categor
 			if v.Name != "" {
 				product.Name = v.Name
 			}
-			update.Products = append(update.Products, product)
+			products = append(products, product)
 		}
+		update["products"] = products
 	}
 
 	// var category category
-	err = tx.Database.Collection(dbName).FindOneAndUpdate(ctx, filter, update).Decode(&category)
+	err = tx.Database.Collection(dbName).FindOneAndUpdate(ctx, filter, bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&category)
 	if err != nil {
 		tx.logger.WithFields(logrus.Fields{
 			"dbName": dbName,
 			"data":   update,
 			"error":  err,
-		}).Error("insert category error")
+		}).Error("update category error")
 		return nil, err
 	}
 
@@ -95,6 +106,36 @@ func (tx *category) Update(req model.UpdateCategoryReq) (category *model.Categor
 		"dbName": dbName,
 		"update": update,
 		"data":   category,
-	}).Debug("insert category success")
+	}).Debug("update category success")
+	return category, nil
+}
+
+func (tx *category) Delete(ctx context.Context, req model.DeleteCategoryReq) (category *model.Category, err error) {
+	dbName := kafka.Shadow("category", tx.suffix)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"id": req.ID, "deleteDate": nil}
+	update := bson.M{"$set": bson.M{"deleteDate": req.DeleteDate}}
+	err = tx.Database.Collection(dbName).FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		// already deleted by an earlier delivery of the same event
+		err = tx.Database.Collection(dbName).FindOne(ctx, bson.M{"id": req.ID}).Decode(&category)
+	}
+	if err != nil {
+		tx.logger.WithFields(logrus.Fields{
+			"dbName": dbName,
+			"data":   req,
+			"error":  err,
+		}).Error("delete category error")
+		return nil, err
+	}
+
+	tx.logger.WithFields(logrus.Fields{
+		"dbName": dbName,
+		"data":   category,
+	}).Debug("delete category success")
 	return category, nil
 }
@@ -1,150 +1,158 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/sing3demons/go-category-service/model"
 	"github.com/sing3demons/go-category-service/repository"
+	"github.com/sing3demons/go-platform/kafka"
 	"github.com/sirupsen/logrus"
 )
 
-type EventHandler interface {
-	Handle(topic string, eventBytes []byte)
-}
-
 type categoryEventHandler struct {
 	categoryRepo repository.CategoryRepository
 	logger       *logrus.Logger
 }
 
-func NewCategoryEventHandler(categoryRepo repository.CategoryRepository, logger *logrus.Logger) EventHandler {
+func NewCategoryEventHandler(categoryRepo repository.CategoryRepository, logger *logrus.Logger) kafka.EventHandler {
 	return &categoryEventHandler{categoryRepo, logger}
 }
 
-type Event struct {
-	Header map[string]any `json:"header"`
-	Body   any            `json:"body"`
+func (obj *categoryEventHandler) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	switch msg.Topic {
+	case "category.created":
+		return obj.createCategory(ctx, msg)
+	case "category.updated":
+		return obj.updateCategory(ctx, msg)
+	case "category.deleted":
+		return obj.deleteCategory(ctx, msg)
+	}
+	return nil
+}
+
+// decode reads the body of msg and returns the metadata from its headers.
+func (obj *categoryEventHandler) decode(msg *sarama.ConsumerMessage, body any) (map[string]string, error) {
+	if err := json.Unmarshal(msg.Value, body); err != nil {
+		obj.logger.WithFields(logrus.Fields{
+			"topic": msg.Topic,
+			"error": err,
+		}).Error("unmarshal event body error")
+		return nil, kafka.Poison(err)
+	}
+	return kafka.Metadata(msg), nil
 }
 
-func (obj *categoryEventHandler) Handle(topic string, eventBytes []byte) {
-	switch topic {
-	case "category.created":
-		var event Event
-		if err := json.Unmarshal(eventBytes, &event); err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("unmarshal event error")
-			return
-		}
-		header := event.Header
-
-		bytes, err := json.Marshal(event.Body)
-		if err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("marshal event body error")
-			return
-		}
-
-		var body model.CreateCategoryReq
-		if err := json.Unmarshal(bytes, &body); err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("marshal event body error")
-			return
-		}
-
-		var doc model.CreateCategoryReq
-
-		doc.ID = body.ID
+func (obj *categoryEventHandler) createCategory(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var body model.CreateCategoryReq
+	header, err := obj.decode(msg, &body)
+	if err != nil {
+		return err
+	}
+
+	var doc model.CreateCategoryReq
+
+	doc.ID = body.ID
+	doc.Name = body.Name
+	doc.Type = "category"
+	doc.Status = body.Status
+
+	if body.LastUpdate.IsZero() {
+		body.LastUpdate = time.Now().UTC()
+	}
+	doc.LastUpdate = body.LastUpdate
+
+	if err := obj.categoryRepo.Save(ctx, doc); err != nil {
+		obj.logger.WithFields(logrus.Fields{
+			"topic": msg.Topic,
+			"heder": header,
+			"body":  doc,
+			"error": err,
+		}).Error("insert category error")
+		return kafka.Retryable(err)
+	}
+	obj.logger.WithFields(logrus.Fields{
+		"topic": msg.Topic,
+		"heder": header,
+		"body":  doc,
+	}).Info("insert category success")
+	return nil
+}
+
+func (obj *categoryEventHandler) updateCategory(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var body model.UpdateCategoryReq
+	header, err := obj.decode(msg, &body)
+	if err != nil {
+		return err
+	}
+
+	var doc model.UpdateCategoryReq
+	doc.ID = body.ID
+	doc.Type = "category"
+	if body.Name != "" {
 		doc.Name = body.Name
-		doc.Type = "category"
+	}
+	if body.Status != "" {
 		doc.Status = body.Status
+	}
+	if len(body.Products) > 0 {
+		doc.Products = body.Products
+	}
+	if body.LastUpdate.IsZero() {
+		body.LastUpdate = time.Now().UTC()
+	}
+	doc.LastUpdate = body.LastUpdate
 
-		if body.LastUpdate.IsZero() {
-			body.LastUpdate = time.Now().UTC()
-		}
-		doc.LastUpdate = body.LastUpdate
-
-		if err := obj.categoryRepo.Save(doc); err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"heder": header,
-				"body":  doc,
-				"error": err,
-			}).Error("insert category error")
-			return
-		}
+	category, err := obj.categoryRepo.Update(ctx, doc)
+	if err != nil {
 		obj.logger.WithFields(logrus.Fields{
-			"topic": topic,
+			"topic": msg.Topic,
 			"heder": header,
 			"body":  doc,
-		}).Info("insert category success")
-	case "category.updated":
-		var event Event
-		if err := json.Unmarshal(eventBytes, &event); err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("unmarshal event error")
-			return
-		}
-		header := event.Header
-
-		bytes, err := json.Marshal(event.Body)
-		if err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("marshal event body error")
-			return
-		}
-
-		var body model.UpdateCategoryReq
-		if err := json.Unmarshal(bytes, &body); err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"error": err,
-			}).Error("marshal event body error")
-			return
-		}
-
-		var doc model.UpdateCategoryReq
-		doc.ID = body.ID
-		doc.Type = "category"
-		if body.Name != "" {
-			doc.Name = body.Name
-		}
-		if body.Status != "" {
-			doc.Status = body.Status
-		}
-		if len(body.Products) > 0 {
-			doc.Products = body.Products
-		}
-		if body.LastUpdate.IsZero() {
-			body.LastUpdate = time.Now().UTC()
-		}
-		doc.LastUpdate = body.LastUpdate
-
-		category, err := obj.categoryRepo.Update(doc)
-		if err != nil {
-			obj.logger.WithFields(logrus.Fields{
-				"topic": topic,
-				"heder": header,
-				"body":  doc,
-				"error": err,
-			}).Error("insert category error")
-			return
-		}
+			"error": err,
+		}).Error("update category error")
+		return kafka.Retryable(err)
+	}
+	obj.logger.WithFields(logrus.Fields{
+		"topic":  msg.Topic,
+		"heder":  header,
+		"body":   doc,
+		"result": category,
+	}).Info("update category success")
+	return nil
+}
+
+func (obj *categoryEventHandler) deleteCategory(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var body model.DeleteCategoryReq
+	header, err := obj.decode(msg, &body)
+	if err != nil {
+		return err
+	}
+	if body.ID == "" {
+		return kafka.Poison(fmt.Errorf("id is required"))
+	}
+	if body.DeleteDate.IsZero() {
+		body.DeleteDate = time.Now().UTC()
+	}
+
+	category, err := obj.categoryRepo.Delete(ctx, body)
+	if err != nil {
 		obj.logger.WithFields(logrus.Fields{
-			"topic":  topic,
-			"heder":  header,
-			"body":   doc,
-			"result": category,
-		}).Info("insert category success")
+			"topic": msg.Topic,
+			"heder": header,
+			"body":  body,
+			"error": err,
+		}).Error("delete category error")
+		return kafka.Retryable(err)
 	}
+	obj.logger.WithFields(logrus.Fields{
+		"topic":  msg.Topic,
+		"heder":  header,
+		"body":   body,
+		"result": category,
+	}).Info("delete category success")
+	return nil
 }
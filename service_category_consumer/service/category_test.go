@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-category-service/model"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// stubRepository holds at most one category. Writes fail with err when it is
+// set.
+type stubRepository struct {
+	category *model.Category
+	err      error
+}
+
+func (r *stubRepository) Save(ctx context.Context, doc model.CreateCategoryReq) error {
+	return r.err
+}
+
+func (r *stubRepository) Update(ctx context.Context, req model.UpdateCategoryReq) (*model.Category, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.category == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.category, nil
+}
+
+func (r *stubRepository) Delete(ctx context.Context, req model.DeleteCategoryReq) (*model.Category, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.category == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.category, nil
+}
+
+func TestCategoryEventHandlerHandle(t *testing.T) {
+	failure := errors.New("connection refused")
+	stored := &model.Category{ID: "c1", Name: "a"}
+
+	cases := []struct {
+		name    string
+		topic   string
+		body    any
+		repo    *stubRepository
+		want    string
+		wantErr error
+	}{
+		{name: "create", topic: "category.created", body: map[string]any{"id": "c1", "name": "a"}, repo: &stubRepository{}, want: "ok"},
+		{name: "create, bad payload", topic: "category.created", body: map[string]any{"id": 1}, repo: &stubRepository{}, want: "poison"},
+		{name: "create, repository error", topic: "category.created", body: map[string]any{"id": "c1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "update", topic: "category.updated", body: map[string]any{"id": "c1", "name": "b"}, repo: &stubRepository{category: stored}, want: "ok"},
+		{name: "update, bad payload", topic: "category.updated", body: map[string]any{"id": "c1", "products": "p1"}, repo: &stubRepository{category: stored}, want: "poison"},
+		{name: "update before create", topic: "category.updated", body: map[string]any{"id": "c1", "name": "b"}, repo: &stubRepository{}, want: "retryable", wantErr: mongo.ErrNoDocuments},
+		{name: "update, repository error", topic: "category.updated", body: map[string]any{"id": "c1"}, repo: &stubRepository{category: stored, err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "delete", topic: "category.deleted", body: map[string]any{"id": "c1"}, repo: &stubRepository{category: stored}, want: "ok"},
+		{name: "delete, bad payload", topic: "category.deleted", body: "c1", repo: &stubRepository{category: stored}, want: "poison"},
+		{name: "delete without id", topic: "category.deleted", body: map[string]any{}, repo: &stubRepository{category: stored}, want: "poison"},
+		{name: "delete, repository error", topic: "category.deleted", body: map[string]any{"id": "c1"}, repo: &stubRepository{category: stored, err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "other topic", topic: "product.created", body: map[string]any{}, repo: &stubRepository{err: failure}, want: "ok"},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := json.Marshal(tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler := NewCategoryEventHandler(tc.repo, logger)
+			err = handler.Handle(context.Background(), &sarama.ConsumerMessage{Topic: tc.topic, Value: value})
+
+			got := "ok"
+			switch {
+			case kafka.IsPoison(err):
+				got = "poison"
+			case err != nil:
+				got = "retryable"
+			}
+			if got != tc.want {
+				t.Fatalf("Handle() = %v, want %s", err, tc.want)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Handle() = %v, want an error wrapping %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -2,51 +2,75 @@ package main
 
 import (
 	"context"
-	"log"
-	"os"
 	"time"
 
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/mongodb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func ConnectMonoDB() (*mongo.Database, error) {
-	dsn := os.Getenv("MONGO_URI")
-	if dsn == "" {
-		dsn = "mongodb://localhost:27017/my_app?authSource=admin"
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dsn))
+func ConnectMonoDB(cfg mongodb.Config, ledgerTTL time.Duration) (*mongo.Database, error) {
+	db, err := mongodb.Connect(cfg)
 	if err != nil {
 		return nil, err
 	}
+	createIndexes(db, "", ledgerTTL)
 
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		return nil, err
-	}
+	return db, nil
+}
+
+// collections are the projections of the consumed topics, see Replay.
+var collections = []string{
+	"product",
+	"productView",
+	"productViewCategory",
+	"productPrice",
+	"productPriceAudit",
+	"productPriceHistory",
+	"productProcessedEvent",
+}
 
+// createIndexes creates the indexes of the collections named with suffix.
+// Processed events expire after ledgerTTL.
+func createIndexes(db *mongo.Database, suffix string, ledgerTTL time.Duration) {
 	indexModel := mongo.IndexModel{
 		Keys: bson.D{{Key: "id", Value: 1}},
 	}
-	client.Database("my_app").Collection("product").Indexes().CreateOne(context.TODO(), indexModel)
-	client.Database("my_app").Collection("productPrice").Indexes().CreateOne(context.TODO(), indexModel)
-
-	return client.Database("my_app"), nil
-
-	// return collection, nil
-}
-
-func DisconnectMongo(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	err := client.Disconnect(ctx)
-	if err != nil {
-		log.Fatal(err)
+	pageIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "lastUpdate", Value: -1}, {Key: "id", Value: -1}},
 	}
+	productIndexModels := []mongo.IndexModel{indexModel, pageIndexModel, {
+		Keys: bson.D{{Key: "currentPrice.unit", Value: 1}, {Key: "currentPrice.value", Value: 1}, {Key: "id", Value: 1}},
+	}, {
+		Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().SetName("product_text").SetWeights(bson.D{{Key: "title", Value: 5}, {Key: "description", Value: 1}}),
+	}, {
+		Keys: bson.D{{Key: "title", Value: 1}, {Key: "id", Value: 1}},
+	}}
+	db.Collection(kafka.Shadow("product", suffix)).Indexes().CreateMany(context.TODO(), productIndexModels)
+	db.Collection(kafka.Shadow("productView", suffix)).Indexes().CreateMany(context.TODO(), append(productIndexModels, mongo.IndexModel{
+		Keys: bson.D{{Key: "category.id", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "productPrice.id", Value: 1}},
+	}, mongo.IndexModel{
+		Keys: bson.D{{Key: "productPrice.price.unit", Value: 1}, {Key: "productPrice.price.value", Value: 1}},
+	}))
+	db.Collection(kafka.Shadow("productViewCategory", suffix)).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	db.Collection(kafka.Shadow("productPrice", suffix)).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{indexModel, pageIndexModel})
+	db.Collection(kafka.Shadow("productPriceAudit", suffix)).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "eventId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "priceId", Value: 1}, {Key: "changedAt", Value: -1}}},
+	})
+	db.Collection(kafka.Shadow("productPriceHistory", suffix)).Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "eventId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "priceId", Value: 1}, {Key: "effectiveFrom", Value: -1}}},
+		{Keys: bson.D{{Key: "startApplied", Value: 1}, {Key: "effectiveFrom", Value: 1}}},
+		{Keys: bson.D{{Key: "endApplied", Value: 1}, {Key: "effectiveTo", Value: 1}}},
+	})
+	kafka.CreateLedgerIndexes(context.TODO(), db.Collection(kafka.Shadow("productProcessedEvent", suffix)), ledgerTTL)
 }
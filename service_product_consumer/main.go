@@ -2,9 +2,14 @@ package main
 
 import (
 	"os"
-	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/sing3demons/go-platform/auth"
+	"github.com/sing3demons/go-platform/config"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/logging"
+	"github.com/sing3demons/go-platform/mongodb"
+	logrus "github.com/sirupsen/logrus"
 )
 
 const (
@@ -12,32 +17,55 @@ const (
 	ProductUpdatedTopic        = "product.updated"
 	ProductDeletedTopic        = "product.deleted"
 	ProductProductCreatedTopic = "productPrice.created"
+	ProductPriceUpdatedTopic   = "productPrice.updated"
 	ProductPriceDeleteTopic    = "productPrice.deleted"
+	CategoryCreatedTopic       = "category.created"
+	CategoryUpdatedTopic       = "category.updated"
+	CategoryDeletedTopic       = "category.deleted"
 )
 
-func init() {
-	godotenv.Load(".env")
+// Config is the configuration of the consumer, see config.Load.
+type Config struct {
+	Log      logging.Config       `yaml:"log"`
+	Mongo    mongodb.Config       `yaml:"mongo"`
+	Kafka    kafka.Config         `yaml:"kafka"`
+	Consumer kafka.ConsumerConfig `yaml:"consumer"`
+	Auth     auth.VerifierConfig  `yaml:"auth"`
+	// PriceSweep is how often scheduled price changes are applied, see
+	// services.Service.SweepPriceWindows.
+	PriceSweep time.Duration `yaml:"priceSweep" env:"PRICE_SWEEP_INTERVAL" default:"1m" validate:"required"`
 }
 
 func main() {
-	ms := NewMicroservice()
-
-	broker := os.Getenv("KAFKA_BROKERS")
-	var kafkaBrokers []string
-	if broker != "" {
-		kafkaBrokers = strings.Split(broker, ",")
-	} else {
-		kafkaBrokers = []string{"localhost:9092"}
-	}
+	replay, opts := kafka.ReplayFlags()
+	cfg := Config{Consumer: kafka.ConsumerConfig{GroupID: "product_consumer_group"}}
+	config.Load(&cfg)
+	auth.ConfigureVerifier(cfg.Auth)
+
+	ms := NewMicroservice(cfg)
 
-	consumerGroupID := "product_consumer_group"
 	topics := []string{
 		ProductCreatedTopic,
 		ProductUpdatedTopic,
 		ProductDeletedTopic,
 		ProductProductCreatedTopic,
+		ProductPriceUpdatedTopic,
 		ProductPriceDeleteTopic,
+		CategoryCreatedTopic,
+		CategoryUpdatedTopic,
+		CategoryDeletedTopic,
 	}
 
-	ms.Consume(kafkaBrokers, consumerGroupID, topics)
+	if *replay {
+		if err := ms.Replay(cfg.Kafka, cfg.Consumer, topics, *opts); err != nil {
+			ms.LogError("Replay has failed", logrus.Fields{"error": err})
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := ms.Consume(cfg.Kafka, cfg.Consumer, topics); err != nil {
+		ms.LogError("Consumer has failed", logrus.Fields{"error": err})
+		os.Exit(1)
+	}
 }
@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"errors"
-	"os"
-	"os/signal"
-	"sync"
-	"syscall"
+	"time"
 
-	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-consumer-service/services"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sing3demons/go-platform/logging"
 	logrus "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -17,115 +15,72 @@ type IMicroservice interface {
 	LogInfo(message string, fields logrus.Fields)
 	LogError(message string, fields logrus.Fields)
 	// Consumer Services
-	Consume(servers []string, groupID string, topics []string)
+	Consume(cfg kafka.Config, consumer kafka.ConsumerConfig, topics []string) error
+	Replay(cfg kafka.Config, consumer kafka.ConsumerConfig, topics []string, opts kafka.ReplayOptions) error
 }
 
 type Microservice struct {
 	logger     *logrus.Logger
 	db *mongo.Database
+	priceSweep time.Duration
 }
 
-func NewMicroservice() IMicroservice {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.InfoLevel)
+func NewMicroservice(cfg Config) IMicroservice {
+	logger := logging.New(cfg.Log)
 
-	db, err := ConnectMonoDB()
+	db, err := ConnectMonoDB(cfg.Mongo, cfg.Consumer.LedgerTTL())
 	if err != nil {
 		logger.Error("Error connecting to MongoDB", err)
 		panic(err)
 	}
 
-	return &Microservice{logger, db}
+	return &Microservice{logger, db, cfg.PriceSweep}
 }
 
-func (ms *Microservice) Consume(servers []string, groupID string, topics []string) {
-	handler := NewConsumerHandler(ms)
-
-	config := sarama.NewConfig()
-	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRange()
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-
-	version, err := sarama.ParseKafkaVersion("1.0.0")
-	if err != nil {
-		ms.LogError("Error parsing Kafka version", logrus.Fields{"error": err})
-	}
-	config.Version = version
-
-	client, err := sarama.NewConsumerGroup(servers, groupID, config)
-	if err != nil {
-		ms.LogError("Error creating consumer group client", logrus.Fields{
-			"error":   err,
-			"topic":   "product.created",
-			"group":   groupID,
-			"brokers": servers,
-			"version": version,
-		})
-		return
-	}
-	defer client.Close()
-
+// Consume handles the events of topics until the service is stopped, see
+// kafka.Run, and meanwhile applies the scheduled price changes. It doesn't
+// start while a replay is swapping its collections in, see kafka.CheckSwap.
+func (ms *Microservice) Consume(cfg kafka.Config, consumer kafka.ConsumerConfig, topics []string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			ms.logger.Info("Kafka consumer has been started...")
-			if err := client.Consume(ctx, topics, handler); err != nil {
-				if errors.Is(err, sarama.ErrClosedConsumerGroup) {
-					return
-				}
-				ms.LogError("Error from consumer", logrus.Fields{"error": err})
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				ms.LogError("Context has been cancelled", logrus.Fields{"error": ctx.Err()})
-				return
-			}
+	if err := kafka.CheckSwap(ctx, ms.db); err != nil {
+		return err
+	}
 
-		}
+	go func() {
+		svc := services.NewService(services.NewRepository(ms.db, ""), ms.logger)
+		svc.Backfill(ctx)
+		svc.SweepPriceWindows(ctx, ms.priceSweep)
 	}()
 
-	// Handle graceful shutdown
-	consumptionIsPaused := false
-	sigusr1 := make(chan os.Signal, 1)
-	signal.Notify(sigusr1, syscall.SIGUSR1)
+	return kafka.Run(ctx, cfg, consumer, topics, newEventHandler(ms.db, "", "", ms.logger), ms.logger)
+}
 
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case <-sigterm:
-		ms.logger.Info("Received termination signal. Initiating shutdown...")
-		cancel()
-	case <-ctx.Done():
-		ms.logger.Info("terminating: context cancelled")
-	case <-sigusr1:
-		ms.toggleConsumptionFlow(client, &consumptionIsPaused)
-	}
-	// Wait for the consumer to finish processing
-	wg.Wait()
+// Replay rebuilds the collections of the consumer from topics, see
+// kafka.Replay.
+func (ms *Microservice) Replay(cfg kafka.Config, consumer kafka.ConsumerConfig, topics []string, opts kafka.ReplayOptions) error {
+	return kafka.Replay{
+		Brokers:     cfg.Brokers,
+		GroupID:     consumer.GroupID,
+		Topics:      topics,
+		DB:          ms.db,
+		Collections: collections,
+		Policy:      consumer.Retry,
+		Logger:      ms.logger,
+		Indexes: func(db *mongo.Database, suffix string) {
+			createIndexes(db, suffix, consumer.LedgerTTL())
+		},
+		Handler: func(suffix string) kafka.EventHandler {
+			return newEventHandler(ms.db, suffix, consumer.ReplayPolicy, ms.logger)
+		},
+	}.Run(opts)
 }
 
 func (ms *Microservice) Start() {
 	ms.logger.Info("Microservice has been started...")
 }
 
-func (ms *Microservice) toggleConsumptionFlow(client sarama.ConsumerGroup, isPaused *bool) {
-	if *isPaused {
-		client.ResumeAll()
-		ms.logger.Info("Resuming consumption")
-	} else {
-		client.PauseAll()
-		ms.logger.Info("Pausing consumption")
-	}
-
-	*isPaused = !*isPaused
-}
-
 // Log log message to console
 func (ms *Microservice) LogInfo(message string, fields logrus.Fields) {
 	ms.logger.WithFields(fields).Info(message)
@@ -0,0 +1,18 @@
+package services
+
+import "time"
+
+// CategoryRequest is the body of category.created and category.updated.
+// Empty fields of an update are left untouched.
+type CategoryRequest struct {
+	ID         string    `json:"id" bson:"id"`
+	Name       string    `json:"name" bson:"name"`
+	Type       string    `json:"@type" bson:"@type"`
+	Status     string    `json:"status" bson:"status"`
+	LastUpdate time.Time `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+}
+
+type DeleteCategoryRequest struct {
+	ID         string    `json:"id" bson:"id"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
+}
@@ -0,0 +1,5 @@
+package services
+
+import "errors"
+
+var ErrStaleVersion = errors.New("stale version")
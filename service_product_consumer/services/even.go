@@ -3,27 +3,53 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/kafka"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Service struct {
-	db     *mongo.Database
+	repo   Repository
 	logger *logrus.Logger
 }
 
-func NewService(db *mongo.Database, logger *logrus.Logger) *Service {
-	return &Service{db, logger}
+func NewService(repo Repository, logger *logrus.Logger) *Service {
+	return &Service{repo, logger}
 }
 
-func (svc *Service) InsertProduct(topic string, msg []byte, timestamp string) {
-	var event EventCreateProductRequest
-	json.Unmarshal(msg, &event)
-	req := event.Body
+func (svc *Service) Handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	switch msg.Topic {
+	case "product.created":
+		return svc.InsertProduct(ctx, msg)
+	case "product.updated":
+		return svc.UpdateProduct(ctx, msg)
+	case "product.deleted":
+		return svc.DeleteProduct(ctx, msg)
+	case "productPrice.created":
+		return svc.InsertProductPrice(ctx, msg)
+	case "productPrice.updated":
+		return svc.UpdateProductPrice(ctx, msg)
+	case "productPrice.deleted":
+		return svc.DeleteProductPrice(ctx, msg)
+	case "category.created", "category.updated":
+		return svc.UpsertCategory(ctx, msg)
+	case "category.deleted":
+		return svc.DeleteCategory(ctx, msg)
+	}
+	return nil
+}
+
+func (svc *Service) InsertProduct(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req CreateProductRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
 
 	if req.LastUpdate.IsZero() {
 		req.LastUpdate = time.Now().UTC()
@@ -31,6 +57,7 @@ func (svc *Service) InsertProduct(topic string, msg []byte, timestamp string) {
 
 	document := CreateProductRequest{
 		ID:           req.ID,
+		Version:      1,
 		Type:         "products",
 		Status:       req.Status,
 		Title:        req.Title,
@@ -44,153 +71,557 @@ func (svc *Service) InsertProduct(topic string, msg []byte, timestamp string) {
 		document.Category = req.Category
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	dbName := "product"
-	result, err := svc.db.Collection(dbName).InsertOne(ctx, document)
+	data, err := svc.repo.InsertProduct(ctx, document)
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
-			"result":    result,
-			"error":     err,
-			"timestamp": timestamp,
+			"body":  document,
+			"error": err,
 		}).Error("insert product error")
-		return
+		return kafka.Retryable(err)
 	}
 
-	var data CreateProductRequest
-	if err := svc.db.Collection(dbName).FindOne(ctx, bson.M{"_id": result.InsertedID}).Decode(&data); err != nil {
-		svc.logger.WithFields(logrus.Fields{
-			"result":    result,
-			"error":     err,
-			"timestamp": timestamp,
-		}).Error("Error decoding data")
-		return
+	if err := svc.refreshProducts(ctx, bson.M{"id": document.ID}); err != nil {
+		return err
 	}
+
 	svc.logger.WithFields(logrus.Fields{
-		"timestamp": timestamp,
-		"result":    data,
-		"headers":   event.Header,
+		"result":  data,
+		"headers": kafka.Metadata(msg),
 	}).Info("Insert Product")
+	return nil
 }
 
-func (svc *Service) DeleteProduct(topic string, msg []byte, timestamp string) {
-	dbName := "product"
-	var event EventDeleteProductRequest
-	json.Unmarshal(msg, &event)
-	req := event.Body
+func (svc *Service) UpdateProduct(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req UpdateProductRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+	if req.ID == "" {
+		return kafka.Poison(fmt.Errorf("id is required"))
+	}
+
+	if req.LastUpdate.IsZero() {
+		req.LastUpdate = time.Now().UTC()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	set := bson.M{"lastUpdate": req.LastUpdate.UTC(), "lastEventId": kafka.EventID(msg)}
+	if req.Status != nil {
+		set["status"] = *req.Status
+	}
+	if req.Title != nil {
+		set["title"] = *req.Title
+	}
+	if req.Description != nil {
+		set["description"] = *req.Description
+	}
+	if req.Image != nil {
+		set["image"] = *req.Image
+	}
+	if req.ProductPrice != nil {
+		set["productPrice"] = *req.ProductPrice
+	}
+	if req.Category != nil {
+		set["category"] = *req.Category
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var result Product
-	if err := svc.db.Collection(dbName).FindOneAndUpdate(ctx, bson.M{"id": req.ID}, bson.M{
-		"$set": bson.M{
-			"deleteDate": req.DeleteDate,
-		},
-	}).Decode(&result); err != nil {
+	result, err := svc.repo.UpdateProduct(ctx, req.ID, req.Version, set)
+	if err == mongo.ErrNoDocuments {
+		err = svc.checkVersion(ctx, req.ID, req.Version)
+	}
+	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
-			"result":    req,
-			"error":     err,
-			"timestamp": timestamp,
-		}).Error("Error decoding data")
-		return
+			"body":  req,
+			"error": err,
+		}).Error("update product error")
+		return err
+	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"id": req.ID}); err != nil {
+		return err
 	}
 
 	svc.logger.WithFields(logrus.Fields{
-		"result":    result,
-		"headers":   event.Header,
-		"timestamp": timestamp,
-	}).Info("Insert Product")
+		"result":  result,
+		"headers": kafka.Metadata(msg),
+	}).Info("Update Product")
+	return nil
+}
+
+// checkVersion explains why an update matched nothing. An update based on a
+// version that was already superseded is rejected for good; one that is ahead
+// of the stored product waits for the missing events.
+func (svc *Service) checkVersion(ctx context.Context, id string, version int64) error {
+	product, err := svc.repo.FindProduct(ctx, id)
+	if err != nil {
+		return kafka.Retryable(err)
+	}
+	if product.Version > version {
+		return kafka.Poison(fmt.Errorf("product %s: update based on version %d, current version %d: %w", id, version, product.Version, ErrStaleVersion))
+	}
+	if product.Version == version {
+		return kafka.Poison(fmt.Errorf("product %s has been deleted", id))
+	}
+	return kafka.Retryable(fmt.Errorf("product %s: update based on version %d, current version %d", id, version, product.Version))
 }
 
-func (svc *Service) InsertProductPrice(topic string, msg []byte, timestamp string) {
-	dbName := "productPrice"
+func (svc *Service) DeleteProduct(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req DeleteProductRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-	var event EventCreateProductPriceRequest
-	if err := json.Unmarshal(msg, &event); err != nil {
+	result, err := svc.repo.DeleteProduct(ctx, req.ID, req.Version, req.DeleteDate)
+	if err == mongo.ErrNoDocuments {
+		// the creation or the updates the delete follows are still to come
+		err = fmt.Errorf("product %s has not reached version %d: %w", req.ID, req.Version, err)
+	}
+	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
-			"error":     err,
-			"timestamp": timestamp,
-		}).Error("Error decoding data")
-		return
+			"body":  req,
+			"error": err,
+		}).Error("delete product error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"id": req.ID}); err != nil {
+		return err
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"result":  result,
+		"headers": kafka.Metadata(msg),
+	}).Info("Delete Product")
+	return nil
+}
+
+func (svc *Service) InsertProductPrice(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req CreateProductPrice
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
 	}
-	req := event.Body
 
 	if req.LastUpdate.IsZero() {
 		req.LastUpdate = time.Now().UTC()
 	}
 
 	document := CreateProductPrice{
-		ID:         req.ID,
-		Type:       "productPrice",
-		Status:     req.Status,
-		Name:       req.Name,
-		Price:      req.Price,
-		LastUpdate: req.LastUpdate.UTC(),
+		ID:            req.ID,
+		Type:          "productPrice",
+		Status:        req.Status,
+		Name:          req.Name,
+		Price:         req.Price,
+		EffectiveFrom: utcTime(req.EffectiveFrom),
+		EffectiveTo:   utcTime(req.EffectiveTo),
+		LastUpdate:    req.LastUpdate.UTC(),
 	}
 
 	svc.logger.WithFields(logrus.Fields{
-		"timestamp": timestamp,
-		"body":      document,
-		"headers":   event.Header,
+		"body":    document,
+		"headers": kafka.Metadata(msg),
 	}).Debug("")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	result, err := svc.db.Collection(dbName).InsertOne(ctx, document)
+	data, err := svc.repo.InsertProductPrice(ctx, document)
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
-			"result":    result,
-			"error":     err,
-			"timestamp": timestamp,
+			"body":  document,
+			"error": err,
 		}).Error("insert product price error")
-		return
+		return kafka.Retryable(err)
 	}
 
-	var data ProductPrice
-	if err := svc.db.Collection(dbName).FindOne(ctx, bson.M{"_id": result.InsertedID}).Decode(&data); err != nil {
-		svc.logger.WithFields(logrus.Fields{
-			"result":    result,
-			"error":     err,
-			"timestamp": timestamp,
-		}).Error("Error decoding data")
-		return
+	window := PriceWindow{
+		EventID:       kafka.EventID(msg),
+		PriceID:       document.ID,
+		Name:          document.Name,
+		Status:        document.Status,
+		Price:         document.Price,
+		EffectiveFrom: document.LastUpdate,
+		EffectiveTo:   document.EffectiveTo,
+	}
+	if document.EffectiveFrom != nil {
+		window.EffectiveFrom = *document.EffectiveFrom
+	}
+	if err := svc.insertPriceWindow(ctx, window); err != nil {
+		return err
 	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"productPrice.id": document.ID}); err != nil {
+		return err
+	}
+
 	svc.logger.WithFields(logrus.Fields{
-		"result":    data,
-		"headers":   event.Header,
-		"timestamp": timestamp,
+		"result":  data,
+		"headers": kafka.Metadata(msg),
 	}).Info("Insert Product Price")
+	return nil
 }
-func (svc *Service) DeleteProductPrice(topic string, msg []byte, timestamp string) {
-	fmt.Println("DeleteProductPrice")
-	dbName := "productPrice"
-	var event EventDeleProductPriceRequest
-	json.Unmarshal(msg, &event)
-	req := event.Body
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (svc *Service) UpdateProductPrice(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req UpdateProductPrice
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+	if req.ID == "" {
+		return kafka.Poison(fmt.Errorf("id is required"))
+	}
+
+	if req.LastUpdate.IsZero() {
+		req.LastUpdate = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	var result ProductPrice
-	if err := svc.db.Collection(dbName).FindOneAndUpdate(ctx, bson.M{"id": req.ID}, bson.M{
-		"$set": bson.M{
-			"deleteDate": req.DeleteDate,
-		},
-	}).Decode(&result); err != nil {
+	current, err := svc.repo.FindProductPrice(ctx, req.ID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return kafka.Retryable(fmt.Errorf("product price %s not found: %w", req.ID, err))
+		}
+		return kafka.Retryable(err)
+	}
+
+	changed := *current
+	set := bson.M{"lastUpdate": req.LastUpdate.UTC()}
+	if req.Name != nil {
+		set["name"] = *req.Name
+		changed.Name = *req.Name
+	}
+	if req.Status != nil {
+		set["status"] = *req.Status
+		changed.Status = *req.Status
+	}
+	if req.Price != nil {
+		set["price"] = *req.Price
+		changed.Price = *req.Price
+	}
+	changed.LastUpdate = req.LastUpdate.UTC()
+	changed.EffectiveFrom = utcTime(req.EffectiveFrom)
+	changed.EffectiveTo = utcTime(req.EffectiveTo)
+
+	from := changed.LastUpdate
+	if changed.EffectiveFrom != nil {
+		from = *changed.EffectiveFrom
+	}
+
+	// The history is written first, so ApplyPriceWindows never reverts the
+	// stored price to a window older than it.
+	window := PriceWindow{
+		EventID:       kafka.EventID(msg),
+		PriceID:       req.ID,
+		Name:          changed.Name,
+		Status:        changed.Status,
+		Price:         changed.Price,
+		EffectiveFrom: from,
+		EffectiveTo:   changed.EffectiveTo,
+	}
+	if err := svc.insertPriceWindow(ctx, window); err != nil {
+		return err
+	}
+
+	// Scheduled and time-boxed changes are applied by ApplyPriceWindows as
+	// their boundaries pass; the stored price keeps describing the price that
+	// is valid now.
+	before, after := current, &changed
+	if changed.EffectiveTo == nil && !from.After(changed.LastUpdate) {
+		after, err = svc.repo.UpdateProductPrice(ctx, current, set)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = fmt.Errorf("product price %s changed while it was updated: %w", req.ID, err)
+		}
+		if err != nil {
+			svc.logger.WithFields(logrus.Fields{
+				"body":  req,
+				"error": err,
+			}).Error("update product price error")
+			return kafka.Retryable(err)
+		}
+
+		if err := svc.refreshProducts(ctx, bson.M{"productPrice.id": req.ID}); err != nil {
+			return err
+		}
+	}
+
+	audit := ProductPriceAudit{
+		EventID:   kafka.EventID(msg),
+		PriceID:   req.ID,
+		Before:    *before,
+		After:     *after,
+		ChangedAt: req.LastUpdate.UTC(),
+	}
+	if err := svc.repo.InsertProductPriceAudit(ctx, audit); err != nil {
 		svc.logger.WithFields(logrus.Fields{
-			"result":    req,
-			"error":     err,
-			"timestamp": timestamp,
-		}).Error("Error decoding data")
-		return
+			"audit": audit,
+			"error": err,
+		}).Error("insert product price audit error")
+		return kafka.Retryable(err)
 	}
 
 	svc.logger.WithFields(logrus.Fields{
-		"result":    result,
-		"headers":   event.Header,
-		"timestamp": timestamp,
-	}).Info("Insert Product")
+		"result":  after,
+		"headers": kafka.Metadata(msg),
+	}).Info("Update Product Price")
+	return nil
+}
+
+func (svc *Service) DeleteProductPrice(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req DeleteProductPriceRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+	if req.DeleteDate.IsZero() {
+		req.DeleteDate = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := svc.repo.DeleteProductPrice(ctx, req.ID, req.DeleteDate)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"body":  req,
+			"error": err,
+		}).Error("delete product price error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.repo.ClosePriceWindows(ctx, req.ID, req.DeleteDate.UTC()); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"body":  req,
+			"error": err,
+		}).Error("close product price windows error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"productPrice.id": req.ID}); err != nil {
+		return err
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"result":  result,
+		"headers": kafka.Metadata(msg),
+	}).Info("Delete Product Price")
+	return nil
+}
+
+// UpsertCategory keeps the copy of a category embedded in the product views.
+func (svc *Service) UpsertCategory(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req CategoryRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+	if req.ID == "" {
+		return kafka.Poison(fmt.Errorf("id is required"))
+	}
+	if req.LastUpdate.IsZero() {
+		req.LastUpdate = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	category := ViewCategory{
+		ID:         req.ID,
+		Name:       req.Name,
+		Status:     req.Status,
+		LastUpdate: req.LastUpdate.UTC(),
+	}
+	if err := svc.repo.UpsertViewCategory(ctx, category); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"body":  req,
+			"error": err,
+		}).Error("upsert view category error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"category.id": req.ID}); err != nil {
+		return err
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"category": req.ID,
+		"headers":  kafka.Metadata(msg),
+	}).Info("Upsert Category")
+	return nil
+}
+
+func (svc *Service) DeleteCategory(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	var req DeleteCategoryRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return kafka.Poison(err)
+	}
+	if req.ID == "" {
+		return kafka.Poison(fmt.Errorf("id is required"))
+	}
+	if req.DeleteDate.IsZero() {
+		req.DeleteDate = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	modified, err := svc.repo.RemoveCategory(ctx, req.ID, req.DeleteDate.UTC())
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"body":  req,
+			"error": err,
+		}).Error("remove category from products error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.repo.DeleteViewCategory(ctx, req.ID, req.DeleteDate.UTC()); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"body":  req,
+			"error": err,
+		}).Error("delete view category error")
+		return kafka.Retryable(err)
+	}
+
+	if err := svc.refreshProducts(ctx, bson.M{"category.id": req.ID}); err != nil {
+		return err
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"category": req.ID,
+		"modified": modified,
+		"headers":  kafka.Metadata(msg),
+	}).Info("Remove Category From Products")
+	return nil
+}
+
+func (svc *Service) insertPriceWindow(ctx context.Context, window PriceWindow) error {
+	if window.EffectiveTo != nil && !window.EffectiveTo.After(window.EffectiveFrom) {
+		return kafka.Poison(fmt.Errorf("effectiveTo must be after effectiveFrom"))
+	}
+	if err := svc.repo.InsertPriceWindow(ctx, window); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"window": window,
+			"error":  err,
+		}).Error("insert product price window error")
+		return kafka.Retryable(err)
+	}
+	return nil
+}
+
+// ApplyPriceWindows promotes the windows of the price history that started by
+// now to the stored prices, and reverts those that ended to the window in
+// effect again, so scheduled and time-boxed changes take effect as their
+// boundaries pass. It is run periodically, see SweepPriceWindows.
+func (svc *Service) ApplyPriceWindows(ctx context.Context, now time.Time) error {
+	ids, err := svc.repo.DuePriceWindows(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := svc.applyPriceWindow(ctx, id, now); err != nil {
+			svc.logger.WithFields(logrus.Fields{
+				"priceId": id,
+				"error":   err,
+			}).Error("apply price window error")
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (svc *Service) applyPriceWindow(ctx context.Context, id string, now time.Time) error {
+	current, err := svc.repo.FindProductPrice(ctx, id)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	if current != nil {
+		window, err := svc.repo.FindPriceWindow(ctx, id, now)
+		switch {
+		case err == nil:
+			if err := svc.repo.ApplyPriceWindow(ctx, current, *window, now); err != nil {
+				// a price changed meanwhile is applied by the next run
+				return err
+			}
+		case !errors.Is(err, mongo.ErrNoDocuments):
+			return err
+		}
+		// without a window in effect the price keeps the values of the last
+		// one, whose effectiveTo has passed
+	}
+
+	if err := svc.repo.MarkPriceWindowsApplied(ctx, id, now); err != nil {
+		return err
+	}
+	return svc.refreshProducts(ctx, bson.M{"productPrice.id": id})
+}
+
+// Backfill derives what products stored before it was introduced lack: their
+// current prices and their productView documents, which GET /products lists.
+func (svc *Service) Backfill(ctx context.Context) {
+	ids, err := svc.repo.FindProductIDs(ctx, bson.M{"currentPrices": bson.M{"$exists": false}})
+	if err == nil {
+		var missing []string
+		missing, err = svc.repo.FindMissingViews(ctx)
+		ids = append(ids, missing...)
+	}
+	if err == nil && len(ids) > 0 {
+		err = svc.refreshProducts(ctx, bson.M{"id": bson.M{"$in": ids}})
+	}
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{"error": err}).Error("backfill products error")
+		return
+	}
+	svc.logger.WithFields(logrus.Fields{"products": len(ids)}).Info("products have been backfilled")
+}
+
+// SweepPriceWindows runs ApplyPriceWindows every interval until ctx is done.
+func (svc *Service) SweepPriceWindows(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := svc.ApplyPriceWindows(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			svc.logger.WithFields(logrus.Fields{"error": err}).Error("sweep price windows error")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshProducts recomputes what is derived from the products matching
+// filter: their current price and their productView documents.
+func (svc *Service) refreshProducts(ctx context.Context, filter bson.M) error {
+	if err := svc.repo.RefreshCurrentPrice(ctx, filter); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"filter": filter,
+			"error":  err,
+		}).Error("refresh current price error")
+		return kafka.Retryable(err)
+	}
+	if err := svc.repo.RefreshProductView(ctx, filter); err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"filter": filter,
+			"error":  err,
+		}).Error("refresh product view error")
+		return kafka.Retryable(err)
+	}
+	return nil
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
 }
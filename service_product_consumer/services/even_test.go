@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// stubRepository holds at most one product and one price. Writes fail with
+// err when it is set.
+type stubRepository struct {
+	Repository
+
+	product *Product
+	price   *ProductPrice
+	err     error
+}
+
+func (r *stubRepository) InsertProduct(ctx context.Context, document CreateProductRequest) (*CreateProductRequest, error) {
+	return &document, r.err
+}
+
+func (r *stubRepository) FindProduct(ctx context.Context, id string) (*Product, error) {
+	if r.product == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.product, nil
+}
+
+func (r *stubRepository) UpdateProduct(ctx context.Context, id string, version int64, set bson.M) (*Product, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.product == nil || r.product.Version != version {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.product, nil
+}
+
+func (r *stubRepository) DeleteProduct(ctx context.Context, id string, version int64, deleteDate time.Time) (*Product, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.product == nil || r.product.Version < version {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.product, nil
+}
+
+func (r *stubRepository) RefreshCurrentPrice(ctx context.Context, filter bson.M) error {
+	return r.err
+}
+
+func (r *stubRepository) RefreshProductView(ctx context.Context, filter bson.M) error {
+	return r.err
+}
+
+func (r *stubRepository) InsertProductPrice(ctx context.Context, document CreateProductPrice) (*ProductPrice, error) {
+	return &ProductPrice{ID: document.ID}, r.err
+}
+
+func (r *stubRepository) FindProductPrice(ctx context.Context, id string) (*ProductPrice, error) {
+	if r.price == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return r.price, nil
+}
+
+func (r *stubRepository) UpdateProductPrice(ctx context.Context, current *ProductPrice, set bson.M) (*ProductPrice, error) {
+	return current, r.err
+}
+
+func (r *stubRepository) InsertProductPriceAudit(ctx context.Context, audit ProductPriceAudit) error {
+	return r.err
+}
+
+func (r *stubRepository) InsertPriceWindow(ctx context.Context, window PriceWindow) error {
+	return r.err
+}
+
+func (r *stubRepository) ClosePriceWindows(ctx context.Context, priceID string, at time.Time) error {
+	return r.err
+}
+
+func (r *stubRepository) DeleteProductPrice(ctx context.Context, id string, deleteDate time.Time) (*ProductPrice, error) {
+	return &ProductPrice{ID: id}, r.err
+}
+
+func (r *stubRepository) UpsertViewCategory(ctx context.Context, category ViewCategory) error {
+	return r.err
+}
+
+func (r *stubRepository) RemoveCategory(ctx context.Context, categoryID string, lastUpdate time.Time) (int64, error) {
+	return 0, r.err
+}
+
+func (r *stubRepository) DeleteViewCategory(ctx context.Context, id string, deleteDate time.Time) error {
+	return r.err
+}
+
+// outcome classifies the error of a handler the way the retry pipeline does.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case kafka.IsPoison(err):
+		return "poison"
+	default:
+		return "retryable"
+	}
+}
+
+func TestServiceHandle(t *testing.T) {
+	failure := errors.New("connection refused")
+	stored := func() *Product { return &Product{ID: "p1", Version: 2} }
+	price := func() *ProductPrice { return &ProductPrice{ID: "r1", Name: "a", Status: "active"} }
+
+	cases := []struct {
+		name    string
+		topic   string
+		body    any
+		repo    *stubRepository
+		want    string
+		wantErr error
+	}{
+		{name: "create product", topic: "product.created", body: map[string]any{"id": "p1", "title": "a"}, repo: &stubRepository{}, want: "ok"},
+		{name: "create product, bad payload", topic: "product.created", body: "p1", repo: &stubRepository{}, want: "poison"},
+		{name: "create product, repository error", topic: "product.created", body: map[string]any{"id": "p1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "update product", topic: "product.updated", body: map[string]any{"id": "p1", "version": 2, "title": "b"}, repo: &stubRepository{product: stored()}, want: "ok"},
+		{name: "update product, bad payload", topic: "product.updated", body: map[string]any{"id": "p1", "version": "2"}, repo: &stubRepository{product: stored()}, want: "poison"},
+		{name: "update product without id", topic: "product.updated", body: map[string]any{"version": 2}, repo: &stubRepository{product: stored()}, want: "poison"},
+		{name: "update product, stale version", topic: "product.updated", body: map[string]any{"id": "p1", "version": 1}, repo: &stubRepository{product: stored()}, want: "poison", wantErr: ErrStaleVersion},
+		{name: "update product, version ahead", topic: "product.updated", body: map[string]any{"id": "p1", "version": 3}, repo: &stubRepository{product: stored()}, want: "retryable"},
+		{name: "update product not created yet", topic: "product.updated", body: map[string]any{"id": "p1", "version": 1}, repo: &stubRepository{}, want: "retryable"},
+		{name: "update product, repository error", topic: "product.updated", body: map[string]any{"id": "p1", "version": 2}, repo: &stubRepository{product: stored(), err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "delete product", topic: "product.deleted", body: map[string]any{"id": "p1", "version": 2}, repo: &stubRepository{product: stored()}, want: "ok"},
+		{name: "delete product, bad payload", topic: "product.deleted", body: []string{"p1"}, repo: &stubRepository{product: stored()}, want: "poison"},
+		{name: "delete product before its last update", topic: "product.deleted", body: map[string]any{"id": "p1", "version": 3}, repo: &stubRepository{product: stored()}, want: "retryable", wantErr: mongo.ErrNoDocuments},
+		{name: "delete product, repository error", topic: "product.deleted", body: map[string]any{"id": "p1", "version": 2}, repo: &stubRepository{product: stored(), err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "create price", topic: "productPrice.created", body: map[string]any{"id": "r1", "price": map[string]any{"unit": "THB", "value": 10}}, repo: &stubRepository{}, want: "ok"},
+		{name: "create price, bad payload", topic: "productPrice.created", body: map[string]any{"id": "r1", "price": 10}, repo: &stubRepository{}, want: "poison"},
+		{name: "create price ending before it starts", topic: "productPrice.created", body: map[string]any{"id": "r1", "effectiveFrom": "2024-02-01T00:00:00Z", "effectiveTo": "2024-01-01T00:00:00Z"}, repo: &stubRepository{}, want: "poison"},
+		{name: "create price, repository error", topic: "productPrice.created", body: map[string]any{"id": "r1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "update price", topic: "productPrice.updated", body: map[string]any{"id": "r1", "name": "b"}, repo: &stubRepository{price: price()}, want: "ok"},
+		{name: "update price without id", topic: "productPrice.updated", body: map[string]any{"name": "b"}, repo: &stubRepository{price: price()}, want: "poison"},
+		{name: "update price not created yet", topic: "productPrice.updated", body: map[string]any{"id": "r1", "name": "b"}, repo: &stubRepository{}, want: "retryable", wantErr: mongo.ErrNoDocuments},
+		{name: "update price, repository error", topic: "productPrice.updated", body: map[string]any{"id": "r1", "name": "b"}, repo: &stubRepository{price: price(), err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "delete price", topic: "productPrice.deleted", body: map[string]any{"id": "r1"}, repo: &stubRepository{}, want: "ok"},
+		{name: "delete price, bad payload", topic: "productPrice.deleted", body: map[string]any{"id": 1}, repo: &stubRepository{}, want: "poison"},
+		{name: "delete price, repository error", topic: "productPrice.deleted", body: map[string]any{"id": "r1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "update category", topic: "category.updated", body: map[string]any{"id": "c1", "name": "b"}, repo: &stubRepository{}, want: "ok"},
+		{name: "create category without id", topic: "category.created", body: map[string]any{"name": "b"}, repo: &stubRepository{}, want: "poison"},
+		{name: "update category, repository error", topic: "category.updated", body: map[string]any{"id": "c1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "delete category", topic: "category.deleted", body: map[string]any{"id": "c1"}, repo: &stubRepository{}, want: "ok"},
+		{name: "delete category without id", topic: "category.deleted", body: map[string]any{}, repo: &stubRepository{}, want: "poison"},
+		{name: "delete category, repository error", topic: "category.deleted", body: map[string]any{"id": "c1"}, repo: &stubRepository{err: failure}, want: "retryable", wantErr: failure},
+
+		{name: "other topic", topic: "order.created", body: map[string]any{}, repo: &stubRepository{err: failure}, want: "ok"},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewService(tc.repo, logger)
+			err := svc.Handle(context.Background(), event(t, tc.topic, 0, tc.body))
+			if got := outcome(err); got != tc.want {
+				t.Fatalf("Handle() = %v, want %s", err, tc.want)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("Handle() = %v, want an error wrapping %v", err, tc.wantErr)
+			}
+		})
+	}
+}
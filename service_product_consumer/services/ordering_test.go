@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/sing3demons/go-platform/kafka"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryRepository keeps products in memory and records the changes applied
+// to them. Only what the product events use is implemented.
+type memoryRepository struct {
+	Repository
+
+	mu       sync.Mutex
+	products map[string]*Product
+	applied  []string
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{products: map[string]*Product{}}
+}
+
+func (r *memoryRepository) InsertProduct(ctx context.Context, document CreateProductRequest) (*CreateProductRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.products[document.ID]; !ok {
+		r.products[document.ID] = &Product{ID: document.ID, Version: document.Version, Title: document.Title}
+		r.applied = append(r.applied, "created")
+	}
+	return &document, nil
+}
+
+func (r *memoryRepository) FindProduct(ctx context.Context, id string) (*Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	product := *p
+	return &product, nil
+}
+
+func (r *memoryRepository) UpdateProduct(ctx context.Context, id string, version int64, set bson.M) (*Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok || p.DeleteDate != nil || p.Version != version {
+		return nil, mongo.ErrNoDocuments
+	}
+	if title, ok := set["title"].(string); ok {
+		p.Title = title
+	}
+	p.Version++
+	r.applied = append(r.applied, fmt.Sprintf("updated to %d", p.Version))
+	product := *p
+	return &product, nil
+}
+
+func (r *memoryRepository) DeleteProduct(ctx context.Context, id string, version int64, deleteDate time.Time) (*Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.products[id]
+	if !ok || p.Version < version {
+		return nil, mongo.ErrNoDocuments
+	}
+	if p.DeleteDate == nil {
+		p.DeleteDate = &deleteDate
+		r.applied = append(r.applied, "deleted")
+	}
+	product := *p
+	return &product, nil
+}
+
+func (r *memoryRepository) RefreshCurrentPrice(ctx context.Context, filter bson.M) error {
+	return nil
+}
+
+func (r *memoryRepository) RefreshProductView(ctx context.Context, filter bson.M) error {
+	return nil
+}
+
+// topicProducer records the messages the retry pipeline forwards.
+type topicProducer struct {
+	sarama.SyncProducer
+
+	mu       sync.Mutex
+	messages []*sarama.ProducerMessage
+}
+
+func (p *topicProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msg)
+	return 0, int64(len(p.messages)), nil
+}
+
+// take returns the messages forwarded since the last call, by topic.
+func (p *topicProducer) take() map[string][]*sarama.ConsumerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	topics := map[string][]*sarama.ConsumerMessage{}
+	for _, msg := range p.messages {
+		key, _ := msg.Key.Encode()
+		value, _ := msg.Value.Encode()
+		consumed := &sarama.ConsumerMessage{Topic: msg.Topic, Key: key, Value: value, Offset: int64(len(topics[msg.Topic]))}
+		for _, h := range msg.Headers {
+			consumed.Headers = append(consumed.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		topics[msg.Topic] = append(topics[msg.Topic], consumed)
+	}
+	p.messages = nil
+	return topics
+}
+
+type session struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+}
+
+func (s session) Context() context.Context {
+	return s.ctx
+}
+
+func (s session) MarkMessage(*sarama.ConsumerMessage, string) {}
+
+type claim struct {
+	sarama.ConsumerGroupClaim
+	topic    string
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c claim) Topic() string {
+	return c.topic
+}
+
+func (c claim) Partition() int32 {
+	return 0
+}
+
+func (c claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// consume runs a claim per topic concurrently, like a consumer group member
+// that was assigned the same partition of each topic.
+func consume(t *testing.T, handler sarama.ConsumerGroupHandler, topics map[string][]*sarama.ConsumerMessage) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for topic, messages := range topics {
+		c := claim{topic: topic, messages: make(chan *sarama.ConsumerMessage, len(messages))}
+		for _, msg := range messages {
+			c.messages <- msg
+		}
+		close(c.messages)
+
+		wg.Add(1)
+		go func(c claim) {
+			defer wg.Done()
+			if err := handler.ConsumeClaim(session{ctx: context.Background()}, c); err != nil {
+				t.Errorf("consume %s: %v", c.topic, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+func event(t *testing.T, topic string, offset int64, body any) *sarama.ConsumerMessage {
+	t.Helper()
+	value, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{Topic: topic, Key: []byte("p1"), Value: value, Offset: offset}
+}
+
+type consumerTest struct {
+	repo     *memoryRepository
+	producer *topicProducer
+	handler  sarama.ConsumerGroupHandler
+}
+
+func newConsumerTest() *consumerTest {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	repo := newMemoryRepository()
+	producer := &topicProducer{}
+	policy := kafka.RetryPolicy{MaxAttempts: 3, Backoff: 5 * time.Millisecond, RetryTopics: 3}
+	handler := kafka.NewConsumerHandler(NewService(repo, logger), kafka.NewRetryPipeline(policy, producer, logger), logger)
+	return &consumerTest{repo, producer, handler}
+}
+
+// run consumes the rounds of claims one after the other, then the retry
+// topics until nothing is forwarded any more, and returns the messages that
+// were dead-lettered.
+func (c *consumerTest) run(t *testing.T, rounds ...map[string][]*sarama.ConsumerMessage) []*sarama.ConsumerMessage {
+	t.Helper()
+	for _, round := range rounds {
+		consume(t, c.handler, round)
+	}
+
+	var dead []*sarama.ConsumerMessage
+	for forwarded := c.producer.take(); len(forwarded) > 0; forwarded = c.producer.take() {
+		for topic, messages := range forwarded {
+			if strings.HasSuffix(topic, ".dlq") {
+				dead = append(dead, messages...)
+				delete(forwarded, topic)
+			}
+		}
+		consume(t, c.handler, forwarded)
+	}
+	return dead
+}
+
+func TestConsumerHandlerAppliesProductEventsInOrder(t *testing.T) {
+	deleteDate := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	created := event(t, "product.created", 0, map[string]any{"id": "p1", "title": "a"})
+	updated := []*sarama.ConsumerMessage{
+		event(t, "product.updated", 0, map[string]any{"id": "p1", "version": 1, "title": "b"}),
+		event(t, "product.updated", 1, map[string]any{"id": "p1", "version": 2, "title": "c"}),
+	}
+	deleted := event(t, "product.deleted", 0, map[string]any{"id": "p1", "version": 3, "deleteDate": deleteDate})
+
+	cases := []struct {
+		name   string
+		rounds []map[string][]*sarama.ConsumerMessage
+	}{{
+		name: "updates and delete before create",
+		rounds: []map[string][]*sarama.ConsumerMessage{
+			{"product.updated": updated, "product.deleted": {deleted}},
+			{"product.created": {created}},
+		},
+	}, {
+		name: "delete before updates",
+		rounds: []map[string][]*sarama.ConsumerMessage{
+			{"product.created": {created}},
+			{"product.deleted": {deleted}},
+			{"product.updated": updated},
+		},
+	}, {
+		name: "interleaved",
+		rounds: []map[string][]*sarama.ConsumerMessage{
+			{"product.created": {created}, "product.updated": updated, "product.deleted": {deleted}},
+		},
+	}}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newConsumerTest()
+			if dead := c.run(t, tc.rounds...); len(dead) != 0 {
+				t.Fatalf("%d events were dead-lettered", len(dead))
+			}
+
+			want := []string{"created", "updated to 2", "updated to 3", "deleted"}
+			if !slices.Equal(c.repo.applied, want) {
+				t.Errorf("applied %v, want %v", c.repo.applied, want)
+			}
+			p := c.repo.products["p1"]
+			if p.Title != "c" || p.Version != 3 || p.DeleteDate == nil {
+				t.Errorf("product is %q at version %d, deleted %v; want %q at version 3, deleted", p.Title, p.Version, p.DeleteDate, "c")
+			}
+		})
+	}
+}
+
+func TestConsumerHandlerDeadLettersUpdatesBasedOnSupersededVersions(t *testing.T) {
+	c := newConsumerTest()
+	dead := c.run(t,
+		map[string][]*sarama.ConsumerMessage{"product.created": {event(t, "product.created", 0, map[string]any{"id": "p1", "title": "a"})}},
+		map[string][]*sarama.ConsumerMessage{"product.updated": {
+			event(t, "product.updated", 0, map[string]any{"id": "p1", "version": 1, "title": "b"}),
+			event(t, "product.updated", 1, map[string]any{"id": "p1", "version": 1, "title": "c"}),
+		}},
+	)
+
+	if len(dead) != 1 || dead[0].Topic != "product.updated.dlq" || dead[0].Offset != 0 {
+		t.Fatalf("dead-lettered %v, want the second update on product.updated.dlq", dead)
+	}
+	if p := c.repo.products["p1"]; p.Title != "b" || p.Version != 2 {
+		t.Errorf("product is %q at version %d, want %q at version 2", p.Title, p.Version, "b")
+	}
+}
@@ -3,37 +3,63 @@ package services
 import "time"
 
 type ProductPrice struct {
-	ID         string    `json:"id" bson:"id"`
-	Type       string    `json:"@type" bson:"@type"`
-	Status     string    `json:"status" bson:"status"`
-	Href       string    `json:"href"`
-	Name       string    `json:"name" bson:"name"`
-	Price      Price     `json:"price,omitempty" bson:"price,omitempty"`
-	LastUpdate time.Time `json:"lastUpdate" bson:"lastUpdate"`
+	ID            string     `json:"id" bson:"id"`
+	Type          string     `json:"@type" bson:"@type"`
+	Status        string     `json:"status" bson:"status"`
+	Href          string     `json:"href"`
+	Name          string     `json:"name" bson:"name"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
 }
 
 type DeleteProductPriceRequest struct {
 	ID         string    `json:"id" bson:"id"`
-	DeleteDate time.Time `json:"delete_date" bson:"deleteDate"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
 }
 
 type CreateProductPrice struct {
-	ID         string    `json:"id" bson:"id"`
-	Type       string    `json:"@type" bson:"@type"`
-	Status     string    `json:"status" bson:"status"`
-	Name       string    `json:"name" bson:"name"`
-	Price      Price     `json:"price,omitempty" bson:"price,omitempty"`
-	LastUpdate time.Time `json:"lastUpdate" bson:"lastUpdate"`
+	ID            string     `json:"id" bson:"id"`
+	Type          string     `json:"@type" bson:"@type"`
+	Status        string     `json:"status" bson:"status"`
+	Name          string     `json:"name" bson:"name"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
+}
+
+type UpdateProductPrice struct {
+	ID            string     `json:"id" bson:"id"`
+	Name          *string    `json:"name,omitempty" bson:"name,omitempty"`
+	Status        *string    `json:"status,omitempty" bson:"status,omitempty"`
+	Price         *Price     `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty" bson:"effectiveFrom,omitempty"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	LastUpdate    time.Time  `json:"lastUpdate" bson:"lastUpdate"`
 }
 
-type EventCreateProductPriceRequest struct {
-	Header map[string]any     `json:"header"`
-	Body   CreateProductPrice `json:"body"`
+// ProductPriceAudit keeps the values a price had before and after a change.
+type ProductPriceAudit struct {
+	EventID   string       `json:"eventId" bson:"eventId"`
+	PriceID   string       `json:"priceId" bson:"priceId"`
+	Before    ProductPrice `json:"before" bson:"before"`
+	After     ProductPrice `json:"after" bson:"after"`
+	ChangedAt time.Time    `json:"changedAt" bson:"changedAt"`
 }
 
-type EventDeleProductPriceRequest struct {
-	Header map[string]any            `json:"header"`
-	Body   DeleteProductPriceRequest `json:"body"`
+// PriceWindow is the price that was valid during [EffectiveFrom, EffectiveTo).
+// When windows overlap the one that started last wins, so a promotion with an
+// end date temporarily overrides an open-ended price.
+type PriceWindow struct {
+	EventID       string     `json:"eventId" bson:"eventId"`
+	PriceID       string     `json:"priceId" bson:"priceId"`
+	Name          string     `json:"name" bson:"name"`
+	Status        string     `json:"status" bson:"status"`
+	Price         Price      `json:"price,omitempty" bson:"price,omitempty"`
+	EffectiveFrom time.Time  `json:"effectiveFrom" bson:"effectiveFrom"`
+	EffectiveTo   *time.Time `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
 }
 
 type Price struct {
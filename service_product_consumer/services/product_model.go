@@ -6,26 +6,29 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-type EventCreateProductRequest struct {
-	Header map[string]any       `json:"header"`
-	Body   CreateProductRequest `json:"body"`
-}
-
+// DeleteProductRequest deletes the product once the updates up to Version
+// have been applied. Events produced before it was set have no version.
 type DeleteProductRequest struct {
 	ID         string    `json:"id" bson:"id"`
-	DeleteDate time.Time `json:"delete_date" bson:"deleteDate"`
+	Version    int64     `json:"version" bson:"version"`
+	DeleteDate time.Time `json:"deleteDate" bson:"deleteDate"`
 }
 type Product struct {
-	MID          primitive.ObjectID         `json:"_id" bson:"_id"`
-	Type         string                     `json:"@type" bson:"@type"`
-	Status       string                     `json:"status" bson:"status"`
-	Category     []Category                 `json:"category,omitempty" bson:"category,omitempty"`
-	Href         string                     `json:"href"`
-	ID           string                     `json:"id" bson:"id"`
-	Title        string                     `json:"title,omitempty" bson:"title,omitempty"`
-	Description  string                     `json:"description,omitempty" bson:"description,omitempty"`
-	Image        string                     `json:"image,omitempty" bson:"image,omitempty"`
-	ProductPrice []CreateUpdateProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty" form:"productPrice,omitempty"`
+	MID           primitive.ObjectID         `json:"_id" bson:"_id"`
+	Type          string                     `json:"@type" bson:"@type"`
+	Status        string                     `json:"status" bson:"status"`
+	Category      []Category                 `json:"category,omitempty" bson:"category,omitempty"`
+	Href          string                     `json:"href"`
+	ID            string                     `json:"id" bson:"id"`
+	Version       int64                      `json:"version" bson:"version"`
+	Title         string                     `json:"title,omitempty" bson:"title,omitempty"`
+	Description   string                     `json:"description,omitempty" bson:"description,omitempty"`
+	Image         string                     `json:"image,omitempty" bson:"image,omitempty"`
+	ProductPrice  []CreateUpdateProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty" form:"productPrice,omitempty"`
+	CurrentPrice  *Price                     `json:"currentPrice,omitempty" bson:"currentPrice,omitempty"`
+	CurrentPrices []Price                    `json:"currentPrices" bson:"currentPrices"`
+	LastUpdate    time.Time                  `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	DeleteDate    *time.Time                 `json:"deleteDate,omitempty" bson:"deleteDate,omitempty"`
 }
 
 type Category struct {
@@ -34,13 +37,21 @@ type Category struct {
 	Type string `json:"@type" bson:"@type"`
 }
 
-type EventDeleteProductRequest struct {
-	Header map[string]any       `json:"header"`
-	Body   DeleteProductRequest `json:"body"`
+type UpdateProductRequest struct {
+	ID           string                      `json:"id" bson:"id"`
+	Version      int64                       `json:"version" bson:"version"`
+	Status       *string                     `json:"status,omitempty" bson:"status,omitempty"`
+	Title        *string                     `json:"title,omitempty" bson:"title,omitempty"`
+	Description  *string                     `json:"description,omitempty" bson:"description,omitempty"`
+	Image        *string                     `json:"image,omitempty" bson:"image,omitempty"`
+	ProductPrice *[]CreateUpdateProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty"`
+	Category     *[]Category                 `json:"category,omitempty" bson:"category,omitempty"`
+	LastUpdate   time.Time                   `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
 }
 
 type CreateProductRequest struct {
 	ID           string                     `json:"id" bson:"id" form:"id"`
+	Version      int64                      `json:"version" bson:"version"`
 	Type         string                     `json:"@type" bson:"@type"`
 	Category     []Category                 `json:"category,omitempty" bson:"category,omitempty"`
 	Status       string                     `json:"status" bson:"status"`
@@ -0,0 +1,595 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sing3demons/go-platform/kafka"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	InsertProduct(ctx context.Context, document CreateProductRequest) (*CreateProductRequest, error)
+	FindProduct(ctx context.Context, id string) (*Product, error)
+	FindProductIDs(ctx context.Context, filter bson.M) ([]string, error)
+	FindMissingViews(ctx context.Context) ([]string, error)
+	UpdateProduct(ctx context.Context, id string, version int64, set bson.M) (*Product, error)
+	DeleteProduct(ctx context.Context, id string, version int64, deleteDate time.Time) (*Product, error)
+	RefreshCurrentPrice(ctx context.Context, filter bson.M) error
+	RemoveCategory(ctx context.Context, categoryID string, lastUpdate time.Time) (int64, error)
+	UpsertViewCategory(ctx context.Context, category ViewCategory) error
+	DeleteViewCategory(ctx context.Context, id string, deleteDate time.Time) error
+	RefreshProductView(ctx context.Context, filter bson.M) error
+	InsertProductPrice(ctx context.Context, document CreateProductPrice) (*ProductPrice, error)
+	FindProductPrice(ctx context.Context, id string) (*ProductPrice, error)
+	UpdateProductPrice(ctx context.Context, current *ProductPrice, set bson.M) (*ProductPrice, error)
+	InsertProductPriceAudit(ctx context.Context, audit ProductPriceAudit) error
+	InsertPriceWindow(ctx context.Context, window PriceWindow) error
+	ClosePriceWindows(ctx context.Context, priceID string, at time.Time) error
+	DuePriceWindows(ctx context.Context, now time.Time) ([]string, error)
+	FindPriceWindow(ctx context.Context, priceID string, at time.Time) (*PriceWindow, error)
+	ApplyPriceWindow(ctx context.Context, current *ProductPrice, window PriceWindow, now time.Time) error
+	MarkPriceWindowsApplied(ctx context.Context, priceID string, now time.Time) error
+	DeleteProductPrice(ctx context.Context, id string, deleteDate time.Time) (*ProductPrice, error)
+}
+
+type repository struct {
+	db     *mongo.Database
+	suffix string
+}
+
+// NewRepository returns a repository on the collections named with suffix,
+// see kafka.Shadow. The live collections have no suffix.
+func NewRepository(db *mongo.Database, suffix string) Repository {
+	return &repository{db, suffix}
+}
+
+func (r *repository) collection(name string) *mongo.Collection {
+	return r.db.Collection(kafka.Shadow(name, r.suffix))
+}
+
+func (r *repository) InsertProduct(ctx context.Context, document CreateProductRequest) (*CreateProductRequest, error) {
+	dbName := "product"
+	if err := r.upsert(ctx, dbName, document.ID, document); err != nil {
+		return nil, err
+	}
+
+	var data CreateProductRequest
+	if err := r.collection(dbName).FindOne(ctx, bson.M{"id": document.ID}).Decode(&data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (r *repository) FindProduct(ctx context.Context, id string) (*Product, error) {
+	var result Product
+	if err := r.collection("product").FindOne(ctx, bson.M{"id": id}).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *repository) FindProductIDs(ctx context.Context, filter bson.M) ([]string, error) {
+	values, err := r.collection("product").Distinct(ctx, "id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// FindMissingViews returns the products that have no productView document.
+func (r *repository) FindMissingViews(ctx context.Context) ([]string, error) {
+	cur, err := r.collection("product").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         r.collection("productView").Name(),
+			"localField":   "id",
+			"foreignField": "id",
+			"as":           "view",
+		}}},
+		{{Key: "$match", Value: bson.M{"view": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	if err := cur.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	return ids, nil
+}
+
+// UpdateProduct applies set only when the stored version still equals version
+// and bumps it. mongo.ErrNoDocuments is returned when nothing matched.
+func (r *repository) UpdateProduct(ctx context.Context, id string, version int64, set bson.M) (*Product, error) {
+	filter := bson.M{"id": id, "deleteDate": nil, "version": version}
+	if version == 0 {
+		// products created before versioning have no version field
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	var result Product
+	err := r.collection("product").FindOneAndUpdate(ctx, filter, bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteProduct deletes the product when its stored version reached version.
+// mongo.ErrNoDocuments is returned when nothing matched.
+func (r *repository) DeleteProduct(ctx context.Context, id string, version int64, deleteDate time.Time) (*Product, error) {
+	filter := bson.M{"id": id}
+	if version > 0 {
+		filter["version"] = bson.M{"$gte": version}
+	}
+
+	var result Product
+	err := r.collection("product").FindOneAndUpdate(ctx, filter, bson.M{
+		"$set": bson.M{"deleteDate": deleteDate},
+	}).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RefreshCurrentPrice recomputes the current prices of the products matching
+// filter: currentPrices, the lowest active price per unit in effect now, and
+// currentPrice, that price when the product is priced in a single unit only.
+// Products are sorted by it, so it has to follow every change of a referenced
+// price and every window of the price history that passes, see
+// ApplyPriceWindows.
+func (r *repository) RefreshCurrentPrice(ctx context.Context, filter bson.M) error {
+	cur, err := r.collection("product").Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1, "productPrice": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	now := time.Now().UTC()
+	inEffect := map[string]*Price{}
+	for cur.Next(ctx) {
+		var p Product
+		if err := cur.Decode(&p); err != nil {
+			return err
+		}
+
+		lowest := map[string]Price{}
+		for _, v := range p.ProductPrice {
+			price, ok := inEffect[v.ID]
+			if !ok {
+				if price, err = r.priceInEffect(ctx, v.ID, now); err != nil {
+					return err
+				}
+				inEffect[v.ID] = price
+			}
+			if price == nil {
+				continue
+			}
+			if low, ok := lowest[price.Unit]; !ok || price.Value < low.Value {
+				lowest[price.Unit] = *price
+			}
+		}
+
+		prices := []Price{}
+		for _, price := range lowest {
+			prices = append(prices, price)
+		}
+		slices.SortFunc(prices, func(a, b Price) int { return strings.Compare(a.Unit, b.Unit) })
+
+		update := bson.M{"$set": bson.M{"currentPrices": prices}, "$unset": bson.M{"currentPrice": ""}}
+		if len(prices) == 1 {
+			update = bson.M{"$set": bson.M{"currentPrices": prices, "currentPrice": prices[0]}}
+		}
+		if _, err := r.collection("product").UpdateOne(ctx, bson.M{"id": p.ID}, update); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// priceInEffect returns the value of the price at the given time, nil when it
+// is deleted, inactive or has no value then. Prices without any history keep
+// their stored values.
+func (r *repository) priceInEffect(ctx context.Context, id string, at time.Time) (*Price, error) {
+	price, err := r.FindProductPrice(ctx, id)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status, value := price.Status, price.Price
+	window, err := r.FindPriceWindow(ctx, id, at)
+	switch {
+	case err == nil:
+		status, value = window.Status, window.Price
+	case err != mongo.ErrNoDocuments:
+		return nil, err
+	default:
+		windows, err := r.collection("productPriceHistory").CountDocuments(ctx, bson.M{"priceId": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if windows > 0 {
+			return nil, nil
+		}
+	}
+
+	if status == "inActive" || value.Value == 0 {
+		return nil, nil
+	}
+	return &value, nil
+}
+
+// RemoveCategory drops a deleted category from every product referencing it.
+// The version is left alone: it counts the updates of the product, and
+// bumping it for the cascade would reject updates that are still in flight.
+func (r *repository) RemoveCategory(ctx context.Context, categoryID string, lastUpdate time.Time) (int64, error) {
+	result, err := r.collection("product").UpdateMany(ctx, bson.M{"category.id": categoryID}, bson.M{
+		"$pull": bson.M{"category": bson.M{"id": categoryID}},
+		"$set":  bson.M{"lastUpdate": lastUpdate},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// UpsertViewCategory stores the category unless a newer version of it is
+// already stored.
+func (r *repository) UpsertViewCategory(ctx context.Context, category ViewCategory) error {
+	set := bson.M{"lastUpdate": category.LastUpdate}
+	if category.Name != "" {
+		set["name"] = category.Name
+	}
+	if category.Status != "" {
+		set["status"] = category.Status
+	}
+
+	_, err := r.collection("productViewCategory").UpdateOne(ctx, bson.M{
+		"id":         category.ID,
+		"lastUpdate": bson.M{"$lte": category.LastUpdate},
+	}, bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"id": category.ID, "@type": "category"},
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// the stored category is newer
+		return nil
+	}
+	return err
+}
+
+func (r *repository) DeleteViewCategory(ctx context.Context, id string, deleteDate time.Time) error {
+	_, err := r.collection("productViewCategory").UpdateOne(ctx, bson.M{"id": id}, bson.M{
+		"$set":         bson.M{"deleteDate": deleteDate},
+		"$setOnInsert": bson.M{"id": id, "@type": "category", "lastUpdate": deleteDate},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// RefreshProductView rebuilds the productView documents of the products
+// matching filter, either in the product collection or in the view itself,
+// so references that were just removed are dropped from the view as well.
+func (r *repository) RefreshProductView(ctx context.Context, filter bson.M) error {
+	ids := map[string]bool{}
+	for _, name := range []string{"product", "productView"} {
+		values, err := r.collection(name).Distinct(ctx, "id", filter)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if id, ok := v.(string); ok {
+				ids[id] = true
+			}
+		}
+	}
+
+	for id := range ids {
+		if err := r.refreshProductView(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *repository) refreshProductView(ctx context.Context, id string) error {
+	view := r.collection("productView")
+
+	var product Product
+	err := r.collection("product").FindOne(ctx, bson.M{"id": id}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		_, err = view.DeleteOne(ctx, bson.M{"id": id})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	priceIDs := []string{}
+	for _, v := range product.ProductPrice {
+		priceIDs = append(priceIDs, v.ID)
+	}
+	var prices []ProductPrice
+	cur, err := r.collection("productPrice").Find(ctx, bson.M{"id": bson.M{"$in": priceIDs}, "deleteDate": nil})
+	if err != nil {
+		return err
+	}
+	if err := cur.All(ctx, &prices); err != nil {
+		return err
+	}
+
+	categoryIDs := []string{}
+	for _, v := range product.Category {
+		categoryIDs = append(categoryIDs, v.ID)
+	}
+	var categories []ViewCategory
+	cur, err = r.collection("productViewCategory").Find(ctx, bson.M{"id": bson.M{"$in": categoryIDs}, "deleteDate": nil})
+	if err != nil {
+		return err
+	}
+	if err := cur.All(ctx, &categories); err != nil {
+		return err
+	}
+
+	document := ProductView{
+		ID:            product.ID,
+		Type:          product.Type,
+		Status:        product.Status,
+		Version:       product.Version,
+		Title:         product.Title,
+		Description:   product.Description,
+		Image:         product.Image,
+		CurrentPrice:  product.CurrentPrice,
+		CurrentPrices: product.CurrentPrices,
+		LastUpdate:    product.LastUpdate,
+		DeleteDate:    product.DeleteDate,
+	}
+	for _, v := range product.ProductPrice {
+		price := ProductPrice{ID: v.ID, Type: "productPrice", Name: v.Name}
+		for _, p := range prices {
+			if p.ID == v.ID {
+				price = p
+			}
+		}
+		document.ProductPrice = append(document.ProductPrice, price)
+	}
+	for _, v := range product.Category {
+		category := ViewCategory{ID: v.ID, Type: "category", Name: v.Name}
+		for _, c := range categories {
+			if c.ID == v.ID {
+				category = c
+			}
+		}
+		document.Category = append(document.Category, category)
+	}
+
+	_, err = view.ReplaceOne(ctx, bson.M{"id": id}, document, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *repository) InsertProductPrice(ctx context.Context, document CreateProductPrice) (*ProductPrice, error) {
+	dbName := "productPrice"
+	if err := r.upsert(ctx, dbName, document.ID, document); err != nil {
+		return nil, err
+	}
+
+	var data ProductPrice
+	if err := r.collection(dbName).FindOne(ctx, bson.M{"id": document.ID}).Decode(&data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// upsert only writes document when no document with the same business id
+// exists yet, so a re-delivered create never duplicates or overwrites data.
+func (r *repository) upsert(ctx context.Context, dbName string, id string, document any) error {
+	_, err := r.collection(dbName).UpdateOne(ctx, bson.M{"id": id}, bson.M{
+		"$setOnInsert": document,
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *repository) FindProductPrice(ctx context.Context, id string) (*ProductPrice, error) {
+	var result ProductPrice
+	if err := r.collection("productPrice").FindOne(ctx, bson.M{"id": id, "deleteDate": nil}).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateProductPrice applies set to current and returns the price as stored
+// after it. The update only applies while the stored price is still current,
+// so the pair describes exactly this change; mongo.ErrNoDocuments is returned
+// when the price was changed or deleted meanwhile.
+func (r *repository) UpdateProductPrice(ctx context.Context, current *ProductPrice, set bson.M) (*ProductPrice, error) {
+	var after ProductPrice
+	err := r.collection("productPrice").FindOneAndUpdate(ctx, bson.M{
+		"id":         current.ID,
+		"deleteDate": nil,
+		"lastUpdate": current.LastUpdate,
+	}, bson.M{
+		"$set": set,
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+// InsertProductPriceAudit keeps the first audit written for an event, so a
+// re-delivered update does not overwrite the original "before" values.
+func (r *repository) InsertProductPriceAudit(ctx context.Context, audit ProductPriceAudit) error {
+	_, err := r.collection("productPriceAudit").UpdateOne(ctx, bson.M{"eventId": audit.EventID}, bson.M{
+		"$setOnInsert": audit,
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// InsertPriceWindow adds window to the price history. An open-ended window
+// replaces the open-ended windows that started before it.
+func (r *repository) InsertPriceWindow(ctx context.Context, window PriceWindow) error {
+	collection := r.collection("productPriceHistory")
+	if window.EffectiveTo == nil {
+		_, err := collection.UpdateMany(ctx, bson.M{
+			"priceId":       window.PriceID,
+			"eventId":       bson.M{"$ne": window.EventID},
+			"effectiveTo":   nil,
+			"effectiveFrom": bson.M{"$lt": window.EffectiveFrom},
+		}, bson.M{
+			"$set": bson.M{"effectiveTo": window.EffectiveFrom},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"eventId": window.EventID}, bson.M{
+		"$setOnInsert": window,
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ClosePriceWindows ends every window of the price that is still valid at.
+func (r *repository) ClosePriceWindows(ctx context.Context, priceID string, at time.Time) error {
+	_, err := r.collection("productPriceHistory").UpdateMany(ctx, bson.M{
+		"priceId":       priceID,
+		"effectiveFrom": bson.M{"$lte": at},
+		"$or": []bson.M{
+			{"effectiveTo": nil},
+			{"effectiveTo": bson.M{"$gt": at}},
+		},
+	}, bson.M{
+		"$set": bson.M{"effectiveTo": at},
+	})
+	return err
+}
+
+// DuePriceWindows returns the prices with a window that started or ended by
+// now and was not applied yet, see MarkPriceWindowsApplied.
+func (r *repository) DuePriceWindows(ctx context.Context, now time.Time) ([]string, error) {
+	values, err := r.collection("productPriceHistory").Distinct(ctx, "priceId", bson.M{
+		"$or": []bson.M{
+			{"startApplied": nil, "effectiveFrom": bson.M{"$lte": now}},
+			{"endApplied": nil, "effectiveTo": bson.M{"$lte": now}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// FindPriceWindow returns the window of the price in effect at the given
+// time, the one that started last.
+func (r *repository) FindPriceWindow(ctx context.Context, priceID string, at time.Time) (*PriceWindow, error) {
+	var result PriceWindow
+	err := r.collection("productPriceHistory").FindOne(ctx, bson.M{
+		"priceId":       priceID,
+		"effectiveFrom": bson.M{"$lte": at},
+		"$or": []bson.M{
+			{"effectiveTo": nil},
+			{"effectiveTo": bson.M{"$gt": at}},
+		},
+	}, options.FindOne().SetSort(bson.D{{Key: "effectiveFrom", Value: -1}})).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ApplyPriceWindow stores the values of window as those of the price, unless
+// the price was changed or deleted since current was read, in which case
+// mongo.ErrNoDocuments is returned.
+func (r *repository) ApplyPriceWindow(ctx context.Context, current *ProductPrice, window PriceWindow, now time.Time) error {
+	set := bson.M{
+		"name":          window.Name,
+		"status":        window.Status,
+		"price":         window.Price,
+		"effectiveFrom": window.EffectiveFrom,
+		"lastUpdate":    now,
+	}
+	update := bson.M{"$set": set}
+	if window.EffectiveTo != nil {
+		set["effectiveTo"] = *window.EffectiveTo
+	} else {
+		update["$unset"] = bson.M{"effectiveTo": ""}
+	}
+
+	result, err := r.collection("productPrice").UpdateOne(ctx, bson.M{
+		"id":         current.ID,
+		"deleteDate": nil,
+		"lastUpdate": current.LastUpdate,
+	}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// MarkPriceWindowsApplied records that the boundaries of the windows of the
+// price that passed by now have been applied.
+func (r *repository) MarkPriceWindowsApplied(ctx context.Context, priceID string, now time.Time) error {
+	collection := r.collection("productPriceHistory")
+	if _, err := collection.UpdateMany(ctx, bson.M{
+		"priceId":       priceID,
+		"startApplied":  nil,
+		"effectiveFrom": bson.M{"$lte": now},
+	}, bson.M{"$set": bson.M{"startApplied": true}}); err != nil {
+		return err
+	}
+	_, err := collection.UpdateMany(ctx, bson.M{
+		"priceId":     priceID,
+		"endApplied":  nil,
+		"effectiveTo": bson.M{"$lte": now},
+	}, bson.M{"$set": bson.M{"endApplied": true}})
+	return err
+}
+
+func (r *repository) DeleteProductPrice(ctx context.Context, id string, deleteDate time.Time) (*ProductPrice, error) {
+	var result ProductPrice
+	if err := r.softDelete(ctx, "productPrice", id, deleteDate).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *repository) softDelete(ctx context.Context, dbName string, id string, deleteDate time.Time) *mongo.SingleResult {
+	return r.collection(dbName).FindOneAndUpdate(ctx, bson.M{"id": id}, bson.M{
+		"$set": bson.M{
+			"deleteDate": deleteDate,
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package services
+
+import "time"
+
+// ProductView is a product with its current prices and categories embedded.
+// It is what GET /products lists, so it keeps the field names of Product.
+type ProductView struct {
+	ID            string         `json:"id" bson:"id"`
+	Type          string         `json:"@type" bson:"@type"`
+	Status        string         `json:"status" bson:"status"`
+	Version       int64          `json:"version" bson:"version"`
+	Title         string         `json:"title,omitempty" bson:"title,omitempty"`
+	Description   string         `json:"description,omitempty" bson:"description,omitempty"`
+	Image         string         `json:"image,omitempty" bson:"image,omitempty"`
+	ProductPrice  []ProductPrice `json:"productPrice,omitempty" bson:"productPrice,omitempty"`
+	CurrentPrice  *Price         `json:"currentPrice,omitempty" bson:"currentPrice,omitempty"`
+	CurrentPrices []Price        `json:"currentPrices" bson:"currentPrices"`
+	Category      []ViewCategory `json:"category,omitempty" bson:"category,omitempty"`
+	LastUpdate    time.Time      `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	DeleteDate    *time.Time     `json:"deleteDate,omitempty" bson:"deleteDate,omitempty"`
+}
+
+// ViewCategory is the product consumer's own copy of a category, kept from
+// the category events so views never wait for the category consumer.
+type ViewCategory struct {
+	ID         string     `json:"id" bson:"id"`
+	Type       string     `json:"@type" bson:"@type"`
+	Name       string     `json:"name,omitempty" bson:"name,omitempty"`
+	Status     string     `json:"status,omitempty" bson:"status,omitempty"`
+	LastUpdate time.Time  `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	DeleteDate *time.Time `json:"deleteDate,omitempty" bson:"deleteDate,omitempty"`
+}